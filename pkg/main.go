@@ -11,7 +11,9 @@ import (
 )
 
 func main() {
-	if err := datasource.Manage("grafana-openweather-datasource", plugin.NewDatasource, datasource.ManageOpts{
+	if err := datasource.Manage(plugin.PluginID, plugin.NewDatasource, datasource.ManageOpts{
+		AdmissionHandler:       plugin.NewAdmissionHandler(),
+		QueryConversionHandler: plugin.NewQueryConversionHandler(),
 		TracingOpts: tracing.Opts{
 			CustomAttributes: []attribute.KeyValue{
 				attribute.String("plugin", "grafana-openweather-datasource"),
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// APIKeySource names where the API keys of a datasource were read from
+type APIKeySource string
+
+// Sources of the API keys in order of precedence. The keys stored in the secure JSON data
+// win over the environment variable named by apiKeyEnv, which wins over the file at
+// apiKeyFile. The first source that holds a key is used.
+const (
+	APIKeySourceSecureJSONData APIKeySource = "secureJsonData"
+	APIKeySourceEnv            APIKeySource = "env"
+	APIKeySourceFile           APIKeySource = "file"
+	APIKeySourceNone           APIKeySource = "none"
+)
+
+// ResolveAPIKey finds the API keys of the datasource, see APIKeySource for the precedence.
+// Secrets must already hold the keys from the secure JSON data. A configured key file that
+// cannot be read is an error unless a source with higher precedence holds a key.
+func (s *PluginSettings) ResolveAPIKey() error {
+	if s.Secrets == nil {
+		s.Secrets = &SecretPluginSettings{}
+	}
+
+	switch {
+	case len(s.Secrets.ApiKeys) > 0:
+		s.Secrets.ApiKeySource = APIKeySourceSecureJSONData
+	case s.APIKeyEnv != "" && len(SplitAPIKeys(os.Getenv(s.APIKeyEnv))) > 0:
+		s.Secrets.ApiKeys = SplitAPIKeys(os.Getenv(s.APIKeyEnv))
+		s.Secrets.ApiKeySource = APIKeySourceEnv
+	case s.APIKeyFile != "":
+		keys, err := ReadAPIKeyFile(s.APIKeyFile)
+		if err != nil {
+			return err
+		}
+		s.Secrets.ApiKeys = keys
+		s.Secrets.ApiKeySource = APIKeySourceFile
+	default:
+		s.Secrets.ApiKeySource = APIKeySourceNone
+	}
+
+	if len(s.Secrets.ApiKeys) > 0 {
+		s.Secrets.ApiKey = s.Secrets.ApiKeys[0]
+	}
+	return nil
+}
+
+// ReadAPIKeyFile reads the API keys from a file, like a mounted Kubernetes secret.
+// The file holds one key per line.
+func ReadAPIKeyFile(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read API key file: %w", err)
+	}
+	keys := SplitAPIKeys(string(content))
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("API key file %s is empty", path)
+	}
+	return keys, nil
+}
+
+// SplitAPIKeys splits a list of keys separated by newlines or commas. Surrounding
+// whitespace and duplicates are dropped.
+func SplitAPIKeys(s string) []string {
+	var keys []string
+	seen := map[string]bool{}
+	for _, key := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' || r == '\r' }) {
+		key = strings.TrimSpace(key)
+		if key != "" && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestResolveAPIKeyPrecedence(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "apikey")
+	if err := os.WriteFile(keyFile, []byte("file-key\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("OPENWEATHER_TEST_KEY", "env-key")
+	jsonData := []byte(`{"apiKeyEnv": "OPENWEATHER_TEST_KEY", "apiKeyFile": "` + keyFile + `"}`)
+
+	for _, tc := range []struct {
+		name     string
+		secure   map[string]string
+		jsonData []byte
+		key      string
+		source   APIKeySource
+	}{
+		{"secure json data wins", map[string]string{"apiKey": "secure-key"}, jsonData, "secure-key", APIKeySourceSecureJSONData},
+		{"environment variable", nil, jsonData, "env-key", APIKeySourceEnv},
+		{"unset environment variable falls back to the file", nil,
+			[]byte(`{"apiKeyEnv": "OPENWEATHER_UNSET_KEY", "apiKeyFile": "` + keyFile + `"}`), "file-key", APIKeySourceFile},
+		{"no source", nil, nil, "", APIKeySourceNone},
+	} {
+		settings, err := LoadPluginSettings(backend.DataSourceInstanceSettings{JSONData: tc.jsonData, DecryptedSecureJSONData: tc.secure})
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if settings.Secrets.ApiKey != tc.key || settings.Secrets.ApiKeySource != tc.source {
+			t.Errorf("%s: expected %q from %s, got %q from %s", tc.name, tc.key, tc.source, settings.Secrets.ApiKey, settings.Secrets.ApiKeySource)
+		}
+	}
+}
+
+func TestResolveAPIKeyFileErrors(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+	if _, err := LoadPluginSettings(backend.DataSourceInstanceSettings{JSONData: []byte(`{"apiKeyFile": "` + missing + `"}`)}); err == nil {
+		t.Error("expected an error for a missing key file")
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty")
+	if err := os.WriteFile(empty, []byte("  \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadAPIKeyFile(empty); err == nil {
+		t.Error("expected an error for an empty key file")
+	}
+
+	// The file is not needed when a source with higher precedence holds a key
+	_, err := LoadPluginSettings(backend.DataSourceInstanceSettings{
+		JSONData:                []byte(`{"apiKeyFile": "` + missing + `"}`),
+		DecryptedSecureJSONData: map[string]string{"apiKey": "secure-key"},
+	})
+	if err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestValidateAPIKeyEnv(t *testing.T) {
+	_, err := LoadPluginSettings(backend.DataSourceInstanceSettings{JSONData: []byte(`{"apiKeyEnv": "$OPENWEATHER KEY"}`)})
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Field != "apiKeyEnv" {
+		t.Errorf("expected an apiKeyEnv validation error, got %v", err)
+	}
+}
+
+func TestResolveMultipleAPIKeys(t *testing.T) {
+	t.Setenv("OPENWEATHER_TEST_KEYS", "env-1, env-2,env-1")
+
+	for _, tc := range []struct {
+		name     string
+		secure   map[string]string
+		jsonData []byte
+		keys     []string
+	}{
+		{"secure json data", map[string]string{"apiKey": "key-1", "apiKeys": "key-2,\nkey-3, key-1"}, nil, []string{"key-1", "key-2", "key-3"}},
+		{"only additional keys", map[string]string{"apiKeys": "key-2,key-3"}, nil, []string{"key-2", "key-3"}},
+		{"environment variable", nil, []byte(`{"apiKeyEnv": "OPENWEATHER_TEST_KEYS"}`), []string{"env-1", "env-2"}},
+	} {
+		settings, err := LoadPluginSettings(backend.DataSourceInstanceSettings{JSONData: tc.jsonData, DecryptedSecureJSONData: tc.secure})
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !slices.Equal(settings.Secrets.Keys(), tc.keys) || settings.Secrets.ApiKey != tc.keys[0] {
+			t.Errorf("%s: expected keys %v, got %v (primary %q)", tc.name, tc.keys, settings.Secrets.Keys(), settings.Secrets.ApiKey)
+		}
+	}
+}
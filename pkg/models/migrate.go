@@ -0,0 +1,37 @@
+package models
+
+// CurrentSchemaVersion is the version written by this plugin.
+//
+// Version history:
+//   - 0: unversioned settings with the API URL stored in "path" (backend) or "url" (provisioning)
+//   - 1: a single "apiRoot" plus defaults, timeouts, cache and rate limit settings
+const CurrentSchemaVersion = 1
+
+// migrations upgrade settings from the version they are keyed by to the next version
+var migrations = map[int]func(*PluginSettings){
+	0: migrateV0,
+}
+
+// Migrate upgrades the settings to CurrentSchemaVersion. Settings from a newer
+// version are left untouched and rejected by Validate.
+func (s *PluginSettings) Migrate() {
+	for s.SchemaVersion < CurrentSchemaVersion {
+		if migrate, ok := migrations[s.SchemaVersion]; ok {
+			migrate(s)
+		}
+		s.SchemaVersion++
+	}
+}
+
+// migrateV0 moves the legacy path and url keys into apiRoot. An explicit apiRoot wins,
+// and path wins over the provisioned url.
+func migrateV0(s *PluginSettings) {
+	if s.APIRoot == "" {
+		s.APIRoot = s.Path
+	}
+	if s.APIRoot == "" {
+		s.APIRoot = s.URL
+	}
+	s.Path = ""
+	s.URL = ""
+}
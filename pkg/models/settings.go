@@ -2,34 +2,341 @@ package models
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 )
 
+// DefaultAPIRoot is the root of the OpenWeather API endpoints, except the history API
+const DefaultAPIRoot = "https://api.openweathermap.org"
+
+// DefaultHistoryAPIRoot is the root of the OpenWeather history API, which OpenWeather
+// serves from its own host
+const DefaultHistoryAPIRoot = "https://history.openweathermap.org"
+
+// Defaults applied to settings that are not configured
+const (
+	DefaultUnits              = "metric"
+	DefaultLanguage           = "en"
+	DefaultTimeoutSeconds     = 10
+	DefaultCacheTTLSeconds    = 300
+	DefaultCacheStaleSeconds  = 3600
+	DefaultMaxConcurrency     = 4
+	DefaultRateLimitPerMinute = 60
+	DefaultCacheBackend       = CacheBackendMemory
+
+	// DefaultHealthCheckLocation is tested when neither a health check location nor a
+	// default location is configured
+	DefaultHealthCheckLocation = "London"
+)
+
+// Cache backends, see CacheBackend
+const (
+	CacheBackendMemory = "memory"
+	CacheBackendDisk   = "disk"
+	CacheBackendRedis  = "redis"
+)
+
+// ValidCacheBackends are the supported values of CacheBackend
+var ValidCacheBackends = []string{CacheBackendMemory, CacheBackendDisk, CacheBackendRedis}
+
+// endpointSuffixes are path suffixes that older configurations used to point at a
+// specific endpoint. They are stripped so only the API root remains.
+var endpointSuffixes = []string{
+	"/data/2.5/forecast",
+	"/data/2.5/weather",
+	"/data/2.5/air_pollution",
+	"/data/2.5/history/city",
+	"/data/2.5/onecall",
+	"/data/2.5",
+	"/data/3.0/onecall",
+	"/data/3.0",
+	"/geo/1.0/direct",
+	"/geo/1.0",
+}
+
 type PluginSettings struct {
-	Path    string                `json:"path"`
+	// SchemaVersion is the version of the stored settings, see migrate.go
+	SchemaVersion int `json:"schemaVersion"`
+
+	APIRoot string `json:"apiRoot"`
+	// HistoryAPIRoot is the root of the history API, see HistoryRoot
+	HistoryAPIRoot  string `json:"historyApiRoot,omitempty"`
+	DefaultUnits    string `json:"defaultUnits"`
+	DefaultLanguage string `json:"defaultLanguage"`
+	DefaultLocation string `json:"defaultLocation,omitempty"`
+
+	// HealthCheckLocation is the city or "lat,lon" pair the health check queries, see
+	// HealthCheckTarget
+	HealthCheckLocation string `json:"healthCheckLocation,omitempty"`
+
+	TimeoutSeconds int `json:"timeoutSeconds"`
+	// MaxRetries is how often a call that failed because OpenWeather was unavailable is
+	// sent again. Timeouts are not retried.
+	MaxRetries int `json:"maxRetries,omitempty"`
+	// CacheTTLSeconds is how long upstream responses are cached, 0 disables the cache
+	CacheTTLSeconds int `json:"cacheTTLSeconds"`
+	// CacheStaleSeconds is how long after CacheTTLSeconds a cached response may still be
+	// served, while it is refreshed or while OpenWeather fails. 0 disables serving stale
+	// responses.
+	CacheStaleSeconds int `json:"cacheStaleSeconds"`
+	// CacheBackend stores the cached responses: in the plugin process, in files below
+	// CacheDir that survive restarts, or in the Redis server at RedisAddress that all
+	// Grafana replicas share
+	CacheBackend string `json:"cacheBackend"`
+	CacheDir     string `json:"cacheDir,omitempty"`
+	RedisAddress string `json:"redisAddress,omitempty"`
+	RedisDB      int    `json:"redisDB,omitempty"`
+
+	// MaxConcurrency bounds the queries of a request that run in parallel, 0 runs all
+	// of them in parallel
+	MaxConcurrency int `json:"maxConcurrency"`
+	// RateLimitPerMinute and RateLimitPerDay limit the upstream calls per API key, 0
+	// disables a limit. With SharedRateLimit the calls are counted in the Redis server at
+	// RedisAddress, so the limits hold across all Grafana replicas.
+	RateLimitPerMinute int  `json:"rateLimitPerMinute"`
+	RateLimitPerDay    int  `json:"rateLimitPerDay,omitempty"`
+	SharedRateLimit    bool `json:"sharedRateLimit,omitempty"`
+
+	// APIKeyEnv and APIKeyFile name an environment variable and a file that hold the API
+	// key, for deployments that do not store it in the secure JSON data. See apikey.go.
+	APIKeyEnv  string `json:"apiKeyEnv,omitempty"`
+	APIKeyFile string `json:"apiKeyFile,omitempty"`
+
+	// Path and URL are legacy keys, they are migrated into APIRoot
+	Path string `json:"path,omitempty"`
+	URL  string `json:"url,omitempty"`
+
 	Secrets *SecretPluginSettings `json:"-"`
 }
 
 type SecretPluginSettings struct {
+	// ApiKey is the first of ApiKeys
 	ApiKey string `json:"apiKey"`
+	// ApiKeys are all configured keys. Upstream calls are spread across them.
+	ApiKeys []string `json:"-"`
+	// ApiKeySource is where ApiKeys were read from
+	ApiKeySource APIKeySource `json:"-"`
+	// RedisPassword authenticates against the Redis server
+	RedisPassword string `json:"-"`
 }
 
+// Keys returns all API keys. Settings built without ApiKeys fall back to ApiKey.
+func (s *SecretPluginSettings) Keys() []string {
+	if len(s.ApiKeys) == 0 && s.ApiKey != "" {
+		return []string{s.ApiKey}
+	}
+	return s.ApiKeys
+}
+
+// Timeout returns the timeout for upstream requests
+func (s *PluginSettings) Timeout() time.Duration {
+	return time.Duration(s.TimeoutSeconds) * time.Second
+}
+
+// HealthCheckTarget returns the location the health check queries: the health check
+// location, else the default location, else DefaultHealthCheckLocation
+func (s *PluginSettings) HealthCheckTarget() string {
+	switch {
+	case s.HealthCheckLocation != "":
+		return s.HealthCheckLocation
+	case s.DefaultLocation != "":
+		return s.DefaultLocation
+	default:
+		return DefaultHealthCheckLocation
+	}
+}
+
+// ParseCoordinates parses a location given as "lat,lon". ok is false for city names.
+func ParseCoordinates(location string) (lat float64, lon float64, ok bool) {
+	latStr, lonStr, found := strings.Cut(location, ",")
+	if !found {
+		return 0, 0, false
+	}
+	lat, latErr := strconv.ParseFloat(strings.TrimSpace(latStr), 64)
+	lon, lonErr := strconv.ParseFloat(strings.TrimSpace(lonStr), 64)
+	if latErr != nil || lonErr != nil {
+		return 0, 0, false
+	}
+	return lat, lon, true
+}
+
+// HistoryRoot returns the root of the history API: the configured history API root, else
+// DefaultHistoryAPIRoot for the default API root. A custom API root, like a proxy or a
+// mock, serves the history API as well.
+func (s *PluginSettings) HistoryRoot() string {
+	switch {
+	case s.HistoryAPIRoot != "":
+		return s.HistoryAPIRoot
+	case s.APIRoot == DefaultAPIRoot:
+		return DefaultHistoryAPIRoot
+	default:
+		return s.APIRoot
+	}
+}
+
+// CacheTTL returns how long upstream responses may be cached
+func (s *PluginSettings) CacheTTL() time.Duration {
+	return time.Duration(s.CacheTTLSeconds) * time.Second
+}
+
+// CacheStale returns how long cached responses may be served once they are no longer fresh
+func (s *PluginSettings) CacheStale() time.Duration {
+	return time.Duration(s.CacheStaleSeconds) * time.Second
+}
+
+// UsesRedis reports whether the cache backend or the rate limiter use the Redis server
+func (s *PluginSettings) UsesRedis() bool {
+	return s.CacheBackend == CacheBackendRedis || s.SharedRateLimit
+}
+
+// LoadPluginSettings decodes, migrates, defaults and validates the datasource settings.
+// Validation problems are returned as ValidationErrors.
 func LoadPluginSettings(source backend.DataSourceInstanceSettings) (*PluginSettings, error) {
-	settings := PluginSettings{}
-	err := json.Unmarshal(source.JSONData, &settings)
+	settings, err := loadJSONData(source.JSONData)
 	if err != nil {
-		return nil, fmt.Errorf("could not unmarshal PluginSettings json: %w", err)
+		return nil, err
 	}
 
 	settings.Secrets = loadSecretPluginSettings(source.DecryptedSecureJSONData)
+	if err := settings.ResolveAPIKey(); err != nil {
+		return nil, err
+	}
 
-	return &settings, nil
+	return settings, nil
+}
+
+// loadJSONData decodes the JSON data and brings it to the current schema
+func loadJSONData(jsonData json.RawMessage) (*PluginSettings, error) {
+	settings, err := decodePluginSettings(jsonData)
+	if err != nil {
+		return nil, err
+	}
+
+	settings.Migrate()
+	settings.ApplyDefaults()
+	if err := settings.Validate(); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// NewPluginSettings returns settings that hold the defaults of the settings for which 0 is
+// a valid value, like a cache TTL of 0 that disables the cache. The JSON data is decoded
+// on top of them, so these defaults only apply to keys that are missing.
+func NewPluginSettings() *PluginSettings {
+	return &PluginSettings{
+		CacheTTLSeconds:    DefaultCacheTTLSeconds,
+		CacheStaleSeconds:  DefaultCacheStaleSeconds,
+		MaxConcurrency:     DefaultMaxConcurrency,
+		RateLimitPerMinute: DefaultRateLimitPerMinute,
+	}
+}
+
+// decodePluginSettings unmarshals the JSON data onto NewPluginSettings. Type mismatches are
+// reported per field.
+func decodePluginSettings(jsonData json.RawMessage) (*PluginSettings, error) {
+	settings := NewPluginSettings()
+	if len(jsonData) == 0 {
+		return settings, nil
+	}
+
+	err := json.Unmarshal(jsonData, settings)
+	if err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return nil, ValidationErrors{{
+				Field:   typeErr.Field,
+				Message: fmt.Sprintf("must be of type %s, got %s", typeErr.Type, typeErr.Value),
+			}}
+		}
+		return nil, fmt.Errorf("could not unmarshal PluginSettings json: %w", err)
+	}
+	return settings, nil
+}
+
+// NormalizeJSONData migrates and defaults the stored JSON data and validates the result.
+// Keys that PluginSettings does not know about are kept, legacy keys are removed.
+func NormalizeJSONData(jsonData json.RawMessage) (json.RawMessage, error) {
+	settings, err := loadJSONData(jsonData)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := map[string]interface{}{}
+	if len(jsonData) > 0 {
+		if err := json.Unmarshal(jsonData, &merged); err != nil {
+			return nil, fmt.Errorf("could not unmarshal PluginSettings json: %w", err)
+		}
+	}
+	delete(merged, "path")
+	delete(merged, "url")
+
+	normalized, err := json.Marshal(settings)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(normalized, &merged); err != nil {
+		return nil, err
+	}
+	return json.Marshal(merged)
+}
+
+// ApplyDefaults fills in the settings that are not configured and for which the zero value
+// is not valid. See NewPluginSettings for the others.
+func (s *PluginSettings) ApplyDefaults() {
+	s.APIRoot = NormalizeAPIRoot(s.APIRoot)
+	if s.HistoryAPIRoot != "" {
+		s.HistoryAPIRoot = NormalizeAPIRoot(s.HistoryAPIRoot)
+	}
+	if s.DefaultUnits == "" {
+		s.DefaultUnits = DefaultUnits
+	}
+	if s.DefaultLanguage == "" {
+		s.DefaultLanguage = DefaultLanguage
+	}
+	if s.TimeoutSeconds == 0 {
+		s.TimeoutSeconds = DefaultTimeoutSeconds
+	}
+	if s.CacheBackend == "" {
+		s.CacheBackend = DefaultCacheBackend
+	}
+}
+
+// NormalizeAPIRoot turns a configured URL into an API root. Missing schemes default to
+// https, and URLs that point at a specific endpoint are cut back to the root.
+func NormalizeAPIRoot(root string) string {
+	root = strings.TrimSpace(root)
+	if root == "" {
+		return DefaultAPIRoot
+	}
+	if !strings.Contains(root, "://") {
+		root = "https://" + root
+	}
+
+	// Query strings are built per request
+	if i := strings.IndexAny(root, "?#"); i >= 0 {
+		root = root[:i]
+	}
+	root = strings.TrimRight(root, "/")
+
+	for _, suffix := range endpointSuffixes {
+		if strings.HasSuffix(root, suffix) {
+			return strings.TrimSuffix(root, suffix)
+		}
+	}
+	return root
 }
 
+// loadSecretPluginSettings reads the secure JSON data. apiKey holds the primary key,
+// apiKeys any number of additional keys separated by newlines or commas.
 func loadSecretPluginSettings(source map[string]string) *SecretPluginSettings {
 	return &SecretPluginSettings{
-		ApiKey: source["apiKey"],
+		ApiKeys:       SplitAPIKeys(source["apiKey"] + "\n" + source["apiKeys"]),
+		RedisPassword: source["redisPassword"],
 	}
 }
@@ -0,0 +1,134 @@
+package models
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestNormalizeAPIRoot(t *testing.T) {
+	for in, want := range map[string]string{
+		"":                                DefaultAPIRoot,
+		"api.openweathermap.org":          DefaultAPIRoot,
+		"https://api.openweathermap.org/": DefaultAPIRoot,
+		"https://api.openweathermap.org/data/2.5":                   DefaultAPIRoot,
+		"https://api.openweathermap.org/data/2.5/forecast":          DefaultAPIRoot,
+		"https://api.openweathermap.org/data/2.5/forecast?q=London": DefaultAPIRoot,
+		"http://proxy.local/openweather/data/3.0/onecall":           "http://proxy.local/openweather",
+	} {
+		if got := NormalizeAPIRoot(in); got != want {
+			t.Errorf("NormalizeAPIRoot(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestLoadPluginSettingsMigratesLegacyKeys(t *testing.T) {
+	for name, jsonData := range map[string]string{
+		"apiRoot": `{"apiRoot": "https://api.openweathermap.org"}`,
+		"path":    `{"path": "https://api.openweathermap.org/data/2.5/forecast"}`,
+		"url":     `{"url": "https://api.openweathermap.org/data/2.5"}`,
+		"empty":   ``,
+	} {
+		t.Run(name, func(t *testing.T) {
+			settings, err := LoadPluginSettings(backend.DataSourceInstanceSettings{JSONData: []byte(jsonData)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if settings.APIRoot != DefaultAPIRoot {
+				t.Errorf("unexpected API root %q", settings.APIRoot)
+			}
+			if settings.SchemaVersion != CurrentSchemaVersion {
+				t.Errorf("settings were not migrated to version %d", CurrentSchemaVersion)
+			}
+			if settings.Path != "" || settings.URL != "" {
+				t.Error("legacy keys must be cleared after migration")
+			}
+		})
+	}
+}
+
+func TestLoadPluginSettingsDefaults(t *testing.T) {
+	settings, err := LoadPluginSettings(backend.DataSourceInstanceSettings{
+		JSONData:                []byte(`{}`),
+		DecryptedSecureJSONData: map[string]string{"apiKey": "secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if settings.DefaultUnits != DefaultUnits || settings.DefaultLanguage != DefaultLanguage ||
+		settings.TimeoutSeconds != DefaultTimeoutSeconds || settings.CacheTTLSeconds != DefaultCacheTTLSeconds ||
+		settings.CacheStaleSeconds != DefaultCacheStaleSeconds || settings.CacheBackend != DefaultCacheBackend ||
+		settings.MaxConcurrency != DefaultMaxConcurrency || settings.RateLimitPerMinute != DefaultRateLimitPerMinute {
+		t.Errorf("defaults were not applied: %+v", settings)
+	}
+	if settings.Secrets.ApiKey != "secret" {
+		t.Error("API key was not loaded")
+	}
+}
+
+func TestLoadPluginSettingsKeepsExplicitZeros(t *testing.T) {
+	settings, err := LoadPluginSettings(backend.DataSourceInstanceSettings{
+		JSONData: []byte(`{"cacheTTLSeconds": 0, "cacheStaleSeconds": 0, "maxConcurrency": 0, "rateLimitPerMinute": 0}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.CacheTTLSeconds != 0 || settings.CacheStaleSeconds != 0 || settings.MaxConcurrency != 0 || settings.RateLimitPerMinute != 0 {
+		t.Errorf("expected 0 to disable the cache, stale responses, the concurrency bound and the rate limit, got %+v", settings)
+	}
+}
+
+func TestLoadPluginSettingsValidation(t *testing.T) {
+	_, err := LoadPluginSettings(backend.DataSourceInstanceSettings{
+		JSONData: []byte(`{"apiRoot": "ftp://example.com", "defaultUnits": "kelvin", "timeoutSeconds": -1, "maxConcurrency": 1000, "healthCheckLocation": "95.1,8.7", "cacheBackend": "memcached", "cacheDir": "cache"}`),
+	})
+
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected ValidationErrors, got %v", err)
+	}
+
+	fields := map[string]bool{}
+	for _, e := range errs {
+		fields[e.Field] = true
+	}
+	for _, field := range []string{"apiRoot", "defaultUnits", "timeoutSeconds", "maxConcurrency", "healthCheckLocation", "cacheBackend", "cacheDir"} {
+		if !fields[field] {
+			t.Errorf("expected a validation error for %s, got %v", field, err)
+		}
+	}
+}
+
+func TestLoadPluginSettingsRedis(t *testing.T) {
+	for _, jsonData := range []string{`{"cacheBackend": "redis"}`, `{"sharedRateLimit": true}`} {
+		_, err := LoadPluginSettings(backend.DataSourceInstanceSettings{JSONData: []byte(jsonData)})
+		var errs ValidationErrors
+		if !errors.As(err, &errs) || errs[0].Field != "redisAddress" {
+			t.Fatalf("expected a validation error for redisAddress with %s, got %v", jsonData, err)
+		}
+	}
+
+	settings, err := LoadPluginSettings(backend.DataSourceInstanceSettings{
+		JSONData:                []byte(`{"cacheBackend": "redis", "redisAddress": "redis:6379", "redisDB": 2}`),
+		DecryptedSecureJSONData: map[string]string{"apiKey": "secret", "redisPassword": "hunter2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.RedisAddress != "redis:6379" || settings.RedisDB != 2 || settings.Secrets.RedisPassword != "hunter2" {
+		t.Errorf("redis settings were not loaded: %+v", settings)
+	}
+}
+
+func TestLoadPluginSettingsTypeMismatch(t *testing.T) {
+	_, err := LoadPluginSettings(backend.DataSourceInstanceSettings{
+		JSONData: []byte(`{"timeoutSeconds": "ten"}`),
+	})
+
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || errs[0].Field != "timeoutSeconds" {
+		t.Fatalf("expected a field error for timeoutSeconds, got %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Limits enforced by Validate
+const (
+	MaxTimeoutSeconds = 300
+	MaxConcurrency    = 64
+	MaxRetries        = 5
+)
+
+// ValidUnits are the unit systems supported by OpenWeather
+var ValidUnits = []string{"standard", "metric", "imperial"}
+
+var languagePattern = regexp.MustCompile(`^[a-zA-Z]{2}(_[a-zA-Z]{2})?$`)
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidationError describes a problem with a single settings field
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationErrors collects all problems found in the settings
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return "invalid datasource settings: " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationErrors) add(field string, format string, args ...interface{}) {
+	*e = append(*e, ValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks the settings after migration and defaults have been applied
+func (s *PluginSettings) Validate() error {
+	var errs ValidationErrors
+
+	if s.SchemaVersion > CurrentSchemaVersion {
+		errs.add("schemaVersion", "version %d is newer than the supported version %d", s.SchemaVersion, CurrentSchemaVersion)
+	}
+
+	validateRoot(&errs, "apiRoot", s.APIRoot)
+	if s.HistoryAPIRoot != "" {
+		validateRoot(&errs, "historyApiRoot", s.HistoryAPIRoot)
+	}
+
+	if s.APIKeyEnv != "" && !envNamePattern.MatchString(s.APIKeyEnv) {
+		errs.add("apiKeyEnv", "must be an environment variable name like \"OPENWEATHER_API_KEY\", got %q", s.APIKeyEnv)
+	}
+
+	if lat, lon, ok := ParseCoordinates(s.HealthCheckLocation); ok && (lat < -90 || lat > 90 || lon < -180 || lon > 180) {
+		errs.add("healthCheckLocation", "coordinates must be within -90..90 and -180..180, got %q", s.HealthCheckLocation)
+	}
+
+	if !slices.Contains(ValidUnits, s.DefaultUnits) {
+		errs.add("defaultUnits", "must be one of %s, got %q", strings.Join(ValidUnits, ", "), s.DefaultUnits)
+	}
+	if !languagePattern.MatchString(s.DefaultLanguage) {
+		errs.add("defaultLanguage", "must be a language code like \"en\" or \"zh_cn\", got %q", s.DefaultLanguage)
+	}
+
+	if s.TimeoutSeconds < 1 || s.TimeoutSeconds > MaxTimeoutSeconds {
+		errs.add("timeoutSeconds", "must be between 1 and %d, got %d", MaxTimeoutSeconds, s.TimeoutSeconds)
+	}
+	if s.MaxRetries < 0 || s.MaxRetries > MaxRetries {
+		errs.add("maxRetries", "must be between 0 and %d, got %d", MaxRetries, s.MaxRetries)
+	}
+	if s.CacheTTLSeconds < 0 {
+		errs.add("cacheTTLSeconds", "must not be negative, got %d", s.CacheTTLSeconds)
+	}
+	if s.CacheStaleSeconds < 0 {
+		errs.add("cacheStaleSeconds", "must not be negative, got %d", s.CacheStaleSeconds)
+	}
+	if !slices.Contains(ValidCacheBackends, s.CacheBackend) {
+		errs.add("cacheBackend", "must be one of %s, got %q", strings.Join(ValidCacheBackends, ", "), s.CacheBackend)
+	}
+	if s.UsesRedis() && s.RedisAddress == "" {
+		errs.add("redisAddress", "is required for the redis cache backend and the shared rate limit")
+	}
+	if s.CacheDir != "" && !filepath.IsAbs(s.CacheDir) {
+		errs.add("cacheDir", "must be an absolute path, got %q", s.CacheDir)
+	}
+	if s.RedisDB < 0 {
+		errs.add("redisDB", "must not be negative, got %d", s.RedisDB)
+	}
+	if s.MaxConcurrency < 0 || s.MaxConcurrency > MaxConcurrency {
+		errs.add("maxConcurrency", "must be between 0 and %d, got %d", MaxConcurrency, s.MaxConcurrency)
+	}
+	if s.RateLimitPerMinute < 0 {
+		errs.add("rateLimitPerMinute", "must not be negative, got %d", s.RateLimitPerMinute)
+	}
+	if s.RateLimitPerDay < 0 {
+		errs.add("rateLimitPerDay", "must not be negative, got %d", s.RateLimitPerDay)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateRoot checks that root is an http or https URL with a host
+func validateRoot(errs *ValidationErrors, field string, root string) {
+	if u, err := url.Parse(root); err != nil {
+		errs.add(field, "is not a valid URL: %v", err)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		errs.add(field, "must use http or https, got %q", u.Scheme)
+	} else if u.Host == "" {
+		errs.add(field, "must contain a host")
+	}
+}
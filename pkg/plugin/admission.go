@@ -0,0 +1,116 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// Make sure AdmissionHandler implements the SDK interface
+var _ backend.AdmissionHandler = (*AdmissionHandler)(nil)
+
+// AdmissionHandler validates datasource settings before Grafana stores them, and fills
+// in defaults so a created or provisioned datasource is always on the current schema.
+// It runs per plugin process and therefore does not depend on a datasource instance.
+type AdmissionHandler struct{}
+
+// NewAdmissionHandler creates the admission handler passed to datasource.Manage
+func NewAdmissionHandler() *AdmissionHandler {
+	return &AdmissionHandler{}
+}
+
+// ValidateAdmission rejects settings that LoadPluginSettings would not accept
+func (h *AdmissionHandler) ValidateAdmission(_ context.Context, req *backend.AdmissionRequest) (*backend.ValidationResponse, error) {
+	if req.Operation == backend.AdmissionRequestDelete {
+		return &backend.ValidationResponse{Allowed: true}, nil
+	}
+
+	settings, err := admissionSettings(req)
+	if err != nil {
+		return &backend.ValidationResponse{Result: admissionFailure(err)}, nil
+	}
+
+	config, err := models.LoadPluginSettings(*settings)
+	if err == nil {
+		err = validateAPIKey(req, config)
+	}
+	if err != nil {
+		return &backend.ValidationResponse{Result: admissionFailure(err)}, nil
+	}
+
+	return &backend.ValidationResponse{Allowed: true}, nil
+}
+
+// MutateAdmission migrates the settings and fills in defaults, or rejects invalid settings
+func (h *AdmissionHandler) MutateAdmission(_ context.Context, req *backend.AdmissionRequest) (*backend.MutationResponse, error) {
+	if req.Operation == backend.AdmissionRequestDelete {
+		return &backend.MutationResponse{Allowed: true, ObjectBytes: req.ObjectBytes}, nil
+	}
+
+	settings, err := admissionSettings(req)
+	if err != nil {
+		return &backend.MutationResponse{Result: admissionFailure(err)}, nil
+	}
+
+	jsonData, err := models.NormalizeJSONData(settings.JSONData)
+	if err != nil {
+		return &backend.MutationResponse{Result: admissionFailure(err)}, nil
+	}
+	settings.JSONData = jsonData
+
+	objectBytes, err := backend.DataSourceInstanceSettingsToProtoBytes(settings)
+	if err != nil {
+		return nil, fmt.Errorf("encode datasource settings: %w", err)
+	}
+
+	return &backend.MutationResponse{Allowed: true, ObjectBytes: objectBytes}, nil
+}
+
+// admissionSettings decodes the datasource settings carried by the request
+func admissionSettings(req *backend.AdmissionRequest) (*backend.DataSourceInstanceSettings, error) {
+	settings, err := backend.DataSourceInstanceSettingsFromProto(req.ObjectBytes, PluginID)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode datasource settings: %w", err)
+	}
+	if settings == nil {
+		return nil, errors.New("the request contains no datasource settings")
+	}
+	return settings, nil
+}
+
+// validateAPIKey requires an API key, unless an update keeps the keys that are already
+// stored or the keys are read from an environment variable or file
+func validateAPIKey(req *backend.AdmissionRequest, config *models.PluginSettings) error {
+	if len(config.Secrets.Keys()) > 0 || config.APIKeyEnv != "" || config.APIKeyFile != "" {
+		return nil
+	}
+
+	if req.Operation == backend.AdmissionRequestUpdate {
+		old, err := backend.DataSourceInstanceSettingsFromProto(req.OldObjectBytes, PluginID)
+		if err == nil && old != nil && (old.DecryptedSecureJSONData["apiKey"] != "" || old.DecryptedSecureJSONData["apiKeys"] != "") {
+			return nil
+		}
+	}
+
+	return models.ValidationErrors{{Field: "secureJsonData.apiKey", Message: "is required unless apiKeyEnv or apiKeyFile is set"}}
+}
+
+// admissionFailure converts an error into the result of a rejected admission request
+func admissionFailure(err error) *backend.StatusResult {
+	result := &backend.StatusResult{
+		Status:  "Failure",
+		Message: err.Error(),
+		Reason:  "BadRequest",
+		Code:    http.StatusBadRequest,
+	}
+
+	var validationErrs models.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		result.Reason = "Invalid"
+	}
+	return result
+}
@@ -0,0 +1,103 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func admissionRequest(t *testing.T, op backend.AdmissionRequestOperation, jsonData string, secure map[string]string) *backend.AdmissionRequest {
+	t.Helper()
+	objectBytes, err := backend.DataSourceInstanceSettingsToProtoBytes(&backend.DataSourceInstanceSettings{
+		UID:                     "openweather",
+		JSONData:                []byte(jsonData),
+		DecryptedSecureJSONData: secure,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &backend.AdmissionRequest{Operation: op, ObjectBytes: objectBytes}
+}
+
+func TestValidateAdmission(t *testing.T) {
+	h := NewAdmissionHandler()
+	apiKey := map[string]string{"apiKey": "secret"}
+
+	resp, err := h.ValidateAdmission(context.Background(), admissionRequest(t, backend.AdmissionRequestCreate, `{}`, apiKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Allowed {
+		t.Fatalf("valid settings were rejected: %s", resp.Result.Message)
+	}
+
+	resp, err = h.ValidateAdmission(context.Background(), admissionRequest(t, backend.AdmissionRequestCreate,
+		`{"apiRoot": "ftp://example.com", "timeoutSeconds": -5}`, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Allowed {
+		t.Fatal("invalid settings were allowed")
+	}
+	for _, want := range []string{"apiRoot", "timeoutSeconds"} {
+		if !strings.Contains(resp.Result.Message, want) {
+			t.Errorf("message %q does not mention %s", resp.Result.Message, want)
+		}
+	}
+
+	resp, err = h.ValidateAdmission(context.Background(), admissionRequest(t, backend.AdmissionRequestCreate, `{}`, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Allowed || !strings.Contains(resp.Result.Message, "apiKey") {
+		t.Error("settings without an API key must be rejected")
+	}
+
+	t.Setenv("OPENWEATHER_ADMISSION_KEY", "secret")
+	resp, err = h.ValidateAdmission(context.Background(), admissionRequest(t, backend.AdmissionRequestCreate,
+		`{"apiKeyEnv": "OPENWEATHER_ADMISSION_KEY"}`, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Allowed {
+		t.Errorf("settings reading the API key from the environment were rejected: %s", resp.Result.Message)
+	}
+}
+
+func TestMutateAdmission(t *testing.T) {
+	h := NewAdmissionHandler()
+
+	resp, err := h.MutateAdmission(context.Background(), admissionRequest(t, backend.AdmissionRequestCreate,
+		`{"url": "https://api.openweathermap.org/data/2.5", "custom": "kept"}`, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Allowed {
+		t.Fatalf("mutation was rejected: %s", resp.Result.Message)
+	}
+
+	settings, err := backend.DataSourceInstanceSettingsFromProto(resp.ObjectBytes, PluginID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var jsonData map[string]interface{}
+	if err := json.Unmarshal(settings.JSONData, &jsonData); err != nil {
+		t.Fatal(err)
+	}
+	if jsonData["apiRoot"] != "https://api.openweathermap.org" {
+		t.Errorf("apiRoot was not migrated: %v", jsonData["apiRoot"])
+	}
+	if _, ok := jsonData["url"]; ok {
+		t.Error("legacy url key was not removed")
+	}
+	if jsonData["custom"] != "kept" {
+		t.Error("unknown keys must be preserved")
+	}
+	if jsonData["timeoutSeconds"] != float64(10) {
+		t.Errorf("defaults were not applied: %v", jsonData)
+	}
+}
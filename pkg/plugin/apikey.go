@@ -0,0 +1,76 @@
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/models"
+)
+
+// keyFileCheckInterval is how often the key file is checked for changes
+const keyFileCheckInterval = 10 * time.Second
+
+// apiKeys returns the key pool of the datasource. Keys read from a file are read again
+// when the file changed, so rotated secrets are picked up without saving the settings.
+// The file is checked at most once per keyFileCheckInterval.
+func (d *Datasource) apiKeys() *keyPool {
+	if d.settings.Secrets.ApiKeySource != models.APIKeySourceFile {
+		return d.keys.Load()
+	}
+
+	d.keyMu.Lock()
+	defer d.keyMu.Unlock()
+	pool := d.keys.Load()
+	now := time.Now()
+	if now.Sub(d.keysCheckedAt) < keyFileCheckInterval {
+		return pool
+	}
+	d.keysCheckedAt = now
+
+	info, err := os.Stat(d.settings.APIKeyFile)
+	if err != nil || info.ModTime().Equal(d.keyModTime) {
+		// Keep the last keys while a mounted secret is being replaced
+		return pool
+	}
+
+	keys, err := models.ReadAPIKeyFile(d.settings.APIKeyFile)
+	if err != nil {
+		d.logger.Warn("Could not re-read the API key file, keeping the previous keys", "error", err)
+		return pool
+	}
+	if !slices.Equal(keys, pool.values()) {
+		d.logger.Info("API key file changed, using the new keys", "keys", len(keys))
+		d.redactor.Add(keys...)
+		pool = newKeyPoolFrom(pool, keys, d.metrics)
+		d.keys.Store(pool)
+	}
+	d.keyModTime = info.ModTime()
+	return pool
+}
+
+// initAPIKeys builds the key pool and remembers the state of the key file the settings
+// were loaded from
+func (d *Datasource) initAPIKeys() {
+	d.keys.Store(newKeyPool(d.settings.Secrets.Keys(), d.metrics))
+	if d.settings.Secrets.ApiKeySource != models.APIKeySourceFile {
+		return
+	}
+	d.keysCheckedAt = time.Now()
+	if info, err := os.Stat(d.settings.APIKeyFile); err == nil {
+		d.keyModTime = info.ModTime()
+	}
+}
+
+// describeAPIKeySource tells the user where the API keys of the settings were read from
+func describeAPIKeySource(settings *models.PluginSettings) string {
+	switch settings.Secrets.ApiKeySource {
+	case models.APIKeySourceEnv:
+		return fmt.Sprintf("the environment variable %s", settings.APIKeyEnv)
+	case models.APIKeySourceFile:
+		return fmt.Sprintf("the file %s", settings.APIKeyFile)
+	default:
+		return "the datasource configuration"
+	}
+}
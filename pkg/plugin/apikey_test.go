@@ -0,0 +1,87 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestAPIKeyFileIsReReadWhenChanged(t *testing.T) {
+	server, requests := newTestServer(t)
+
+	keyFile := filepath.Join(t.TempDir(), "apikey")
+	if err := os.WriteFile(keyFile, []byte("first-key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	settings, err := models.LoadPluginSettings(backend.DataSourceInstanceSettings{
+		JSONData: []byte(fmt.Sprintf(`{"apiRoot": %q, "apiKeyFile": %q}`, server.URL, keyFile)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := newTestDatasourceWithSettings(func(s *models.PluginSettings) { *s = *settings })
+
+	// Different cities, so the second query is not answered from the cache
+	query := func(city string) {
+		t.Helper()
+		resp, err := ds.QueryData(context.Background(), queryTypeRequest(
+			backend.DataQuery{RefID: "A", QueryType: QueryTypeCurrent, JSON: []byte(fmt.Sprintf(`{"city": %q}`, city))},
+		))
+		if err != nil || resp.Responses["A"].Error != nil {
+			t.Fatalf("unexpected error %v %v", err, resp.Responses["A"].Error)
+		}
+	}
+
+	query("Marburg")
+	if err := os.WriteFile(keyFile, []byte("second-key\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(keyFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	query("Giessen")
+	if keys := requests.keys(); len(keys) != 2 || keys[1] != "first-key" {
+		t.Fatalf("expected the file not to be checked again within %s, got %v", keyFileCheckInterval, keys)
+	}
+	ds.keyMu.Lock()
+	ds.keysCheckedAt = ds.keysCheckedAt.Add(-keyFileCheckInterval)
+	ds.keyMu.Unlock()
+	query("Kassel")
+
+	if keys := requests.keys(); len(keys) != 3 || keys[2] != "second-key" {
+		t.Errorf("expected the rotated key to be used, got %v", keys)
+	}
+	if got := ds.settings.Secrets.Keys(); !slices.Equal(got, []string{"first-key"}) {
+		t.Errorf("expected the settings to be left unchanged, got %v", got)
+	}
+	if got := ds.redactor.String("second-key"); strings.Contains(got, "second-key") {
+		t.Error("the rotated key is not redacted")
+	}
+}
+
+func TestCheckHealthReportsAPIKeySource(t *testing.T) {
+	server, _ := newTestServer(t)
+	ds := newTestDatasourceWithServer(server)
+	t.Setenv("OPENWEATHER_HEALTH_KEY", "env-key")
+
+	res, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{
+		PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+			JSONData: []byte(fmt.Sprintf(`{"apiRoot": %q, "apiKeyEnv": "OPENWEATHER_HEALTH_KEY"}`, server.URL)),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != backend.HealthStatusOk || !strings.Contains(res.Message, "the environment variable OPENWEATHER_HEALTH_KEY") {
+		t.Errorf("expected the key source in the health message, got %v %q", res.Status, res.Message)
+	}
+}
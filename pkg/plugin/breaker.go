@@ -0,0 +1,137 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/plugin/instrumentation"
+)
+
+// A circuit opens after breakerFailureThreshold consecutive failures of an endpoint. While
+// it is open, calls fail fast. After breakerOpenDuration a single probe is let through,
+// which closes the circuit again if it succeeds.
+const (
+	breakerFailureThreshold = 5
+	breakerOpenDuration     = 30 * time.Second
+)
+
+// errCircuitOpen is wrapped by the upstream errors of calls rejected by an open circuit
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+// breakerState is the state of a circuit, its value is exported as metric
+type breakerState int
+
+const (
+	breakerClosed   breakerState = 0
+	breakerHalfOpen breakerState = 1
+	breakerOpen     breakerState = 2
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker stops calling an endpoint of OpenWeather while it is unavailable, so
+// queries do not wait out the timeout of every request during an outage
+type circuitBreaker struct {
+	endpoint Endpoint
+	logger   *instrumentation.Logger
+	metrics  *instrumentation.Metrics
+	now      func() time.Time
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// newCircuitBreakers returns a closed circuit for every endpoint
+func newCircuitBreakers(logger *instrumentation.Logger, metrics *instrumentation.Metrics) map[Endpoint]*circuitBreaker {
+	breakers := map[Endpoint]*circuitBreaker{}
+	for _, endpoint := range Endpoints() {
+		breakers[endpoint] = &circuitBreaker{endpoint: endpoint, logger: logger, metrics: metrics, now: time.Now}
+		metrics.SetCircuitState(string(endpoint), int(breakerClosed))
+	}
+	return breakers
+}
+
+// allow returns an error if the endpoint must not be called. Once the open duration has
+// passed, one call is allowed as probe.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if wait := b.openedAt.Add(breakerOpenDuration).Sub(b.now()); wait > 0 {
+			return b.openError(wait)
+		}
+		b.setState(breakerHalfOpen)
+		b.probing = true
+		return nil
+	case breakerHalfOpen:
+		if b.probing {
+			return b.openError(0)
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// record reports the outcome of an allowed call. Only an unavailable upstream counts as
+// failure; any response, even an error status, shows that the endpoint is up.
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	switch {
+	case errors.Is(err, context.Canceled):
+		// The caller gave up, this says nothing about the endpoint
+	case errors.Is(err, ErrUpstreamUnavailable):
+		b.failures++
+		if b.state == breakerHalfOpen || b.failures >= breakerFailureThreshold {
+			b.openedAt = b.now()
+			b.setState(breakerOpen)
+		}
+	default:
+		b.failures = 0
+		b.setState(breakerClosed)
+	}
+}
+
+func (b *circuitBreaker) setState(state breakerState) {
+	if b.state == state {
+		return
+	}
+	b.logger.Warn("Circuit breaker changed state", "endpoint", b.endpoint, "from", b.state, "to", state, "failures", b.failures)
+	b.state = state
+	b.metrics.SetCircuitState(string(b.endpoint), int(state))
+}
+
+func (b *circuitBreaker) openError(wait time.Duration) *UpstreamError {
+	message := fmt.Sprintf("OpenWeather API is unavailable: requests to the %s endpoint are paused after %d consecutive failures",
+		b.endpoint, breakerFailureThreshold)
+	if wait > 0 {
+		message += fmt.Sprintf(", the next attempt is made in %s", wait.Round(time.Second))
+	}
+	return &UpstreamError{
+		Kind:     ErrUpstreamUnavailable,
+		Endpoint: b.endpoint,
+		Message:  message,
+		Err:      errCircuitOpen,
+	}
+}
@@ -0,0 +1,115 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/plugin/instrumentation"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	breakers := newCircuitBreakers(instrumentation.WrapLogger(log.New()), instrumentation.NewMetrics("openweather_test").WithDatasource("test"))
+	b := breakers[EndpointForecast]
+	b.now = func() time.Time { return now }
+	unavailable := newStatusError(EndpointForecast, http.StatusServiceUnavailable, "")
+
+	for i := 0; i < breakerFailureThreshold-1; i++ {
+		if err := b.allow(); err != nil {
+			t.Fatalf("expected the circuit to stay closed, got %v", err)
+		}
+		b.record(unavailable)
+	}
+	// Responses, even error responses, reset the failures
+	b.record(newStatusError(EndpointForecast, http.StatusNotFound, ""))
+	for i := 0; i < breakerFailureThreshold; i++ {
+		b.record(unavailable)
+	}
+	if err := b.allow(); !errors.Is(err, errCircuitOpen) || !errors.Is(err, ErrUpstreamUnavailable) {
+		t.Fatalf("expected the open circuit to fail fast, got %v", err)
+	}
+	if got := circuitState(t, EndpointForecast); got != float64(breakerOpen) {
+		t.Errorf("expected the open state as metric, got %v", got)
+	}
+
+	// Half-open lets a single probe through, a failed probe opens the circuit again
+	now = now.Add(breakerOpenDuration)
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected a probe, got %v", err)
+	}
+	if err := b.allow(); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("expected only one probe at a time, got %v", err)
+	}
+	if got := circuitState(t, EndpointForecast); got != float64(breakerHalfOpen) {
+		t.Errorf("expected the half-open state as metric, got %v", got)
+	}
+	b.record(unavailable)
+	if err := b.allow(); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("expected a failed probe to open the circuit, got %v", err)
+	}
+
+	// A canceled probe says nothing about the endpoint, the next call probes again
+	now = now.Add(breakerOpenDuration)
+	if err := b.allow(); err != nil {
+		t.Fatal(err)
+	}
+	b.record(newTransportError(EndpointForecast, context.Canceled))
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected another probe, got %v", err)
+	}
+	b.record(nil)
+	if err := b.allow(); err != nil || b.state != breakerClosed {
+		t.Errorf("expected a successful probe to close the circuit, got %v", err)
+	}
+	if got := circuitState(t, EndpointForecast); got != float64(breakerClosed) {
+		t.Errorf("expected the closed state as metric, got %v", got)
+	}
+}
+
+func TestCircuitBreakerFailsFast(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	server, requests := newTestServer(t, withFailure(&failing))
+	ds := newTestDatasourceWithServer(server)
+
+	for i := 0; i < breakerFailureThreshold; i++ {
+		_, _ = ds.do(context.Background(), EndpointWeather, server.URL+"/data/2.5/weather")
+	}
+
+	start := time.Now()
+	resp, err := ds.QueryData(context.Background(), queryTypeRequest(
+		backend.DataQuery{RefID: "A", QueryType: QueryTypeCurrent, JSON: []byte(`{"city": "Marburg"}`)},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := resp.Responses["A"]
+	if !errors.Is(res.Error, errCircuitOpen) || !strings.Contains(res.Error.Error(), "OpenWeather API is unavailable") ||
+		res.Status != backend.StatusBadGateway {
+		t.Errorf("expected an upstream unavailable error, got %v %v", res.Status, res.Error)
+	}
+	if n := len(requests.paths()); n != breakerFailureThreshold {
+		t.Errorf("expected no request while the circuit is open, got %d", n-breakerFailureThreshold)
+	}
+	if elapsed := time.Since(start); elapsed > retryBackoff {
+		t.Errorf("expected the query to fail without retries, took %s", elapsed)
+	}
+
+	// Other endpoints keep their own circuit
+	if err := ds.breakers[EndpointForecast].allow(); err != nil {
+		t.Errorf("expected the forecast circuit to be closed, got %v", err)
+	}
+}
+
+// circuitState returns the circuit breaker state metric of an endpoint of the test datasource
+func circuitState(t *testing.T, endpoint Endpoint) float64 {
+	t.Helper()
+	return testMetric(t, "circuit_breaker_state", map[string]string{"endpoint": string(endpoint)}).GetGauge().GetValue()
+}
@@ -0,0 +1,335 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/models"
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/plugin/cache"
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/plugin/instrumentation"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// maxCacheEntries bounds the memory used by the in-memory cache of a datasource instance
+const maxCacheEntries = 1000
+
+// redisKeyPrefix namespaces the keys the plugin stores in a shared Redis server
+const redisKeyPrefix = PluginID + ":cache:"
+
+// cacheEntry is a cached upstream response, serialized as JSON into the cache backend
+type cacheEntry struct {
+	Body      json.RawMessage `json:"body"`
+	FetchedAt time.Time       `json:"fetchedAt"`
+}
+
+// cacheBackoff is how long the cache backend is skipped after it failed, so an unreachable
+// Redis server is not waited for on every query
+const cacheBackoff = 30 * time.Second
+
+// responseCache keeps the upstream responses of a datasource instance in a cache backend.
+// Entries are kept for maxAge, the freshness and stale windows together. Backend failures
+// are logged and treated as misses, a broken cache never fails a query. After a failure
+// the backend is skipped for cacheBackoff.
+type responseCache struct {
+	backend cache.Cache
+	maxAge  time.Duration
+	logger  *instrumentation.Logger
+	now     func() time.Time
+
+	mu          sync.Mutex
+	failedUntil time.Time
+}
+
+func newResponseCache(backend cache.Cache, maxAge time.Duration, logger *instrumentation.Logger) *responseCache {
+	return &responseCache{backend: backend, maxAge: maxAge, logger: logger, now: time.Now}
+}
+
+// newCacheBackend creates the cache backend selected in the settings, client is only used
+// by the Redis backend. If the disk cache cannot be created the responses are cached in
+// memory instead.
+func newCacheBackend(config *models.PluginSettings, client redis.UniversalClient, logger *instrumentation.Logger) cache.Cache {
+	switch config.CacheBackend {
+	case models.CacheBackendDisk:
+		dir := config.CacheDir
+		if dir == "" {
+			dir = defaultCacheDir()
+		}
+		disk, err := cache.NewDisk(dir)
+		if err == nil {
+			return disk
+		}
+		logger.Error("Could not create the disk cache, caching in memory instead", "dir", dir, "error", err)
+	case models.CacheBackendRedis:
+		return cache.NewRedis(client, redisKeyPrefix)
+	}
+	return cache.NewMemory(maxCacheEntries)
+}
+
+// defaultCacheDir returns the directory of the disk cache if none is configured: below
+// the Grafana data directory if the plugin knows it, else below the temporary directory
+func defaultCacheDir() string {
+	if data := os.Getenv("GF_PATHS_DATA"); data != "" {
+		return filepath.Join(data, "plugins-cache", PluginID)
+	}
+	return filepath.Join(os.TempDir(), PluginID, "cache")
+}
+
+// available reports whether the backend may be used, false while backing off after a failure
+func (c *responseCache) available() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.now().Before(c.failedUntil)
+}
+
+// failed starts the backoff after a backend failure. Only the first failure is logged.
+func (c *responseCache) failed(ctx context.Context, msg string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.now().Before(c.failedUntil) {
+		return
+	}
+	c.failedUntil = c.now().Add(cacheBackoff)
+	c.logger.FromContext(ctx).Warn(msg, "error", err, "retryIn", cacheBackoff)
+}
+
+func (c *responseCache) get(ctx context.Context, key string) (cacheEntry, bool) {
+	var entry cacheEntry
+	if !c.available() {
+		return entry, false
+	}
+	value, ok, err := c.backend.Get(ctx, key)
+	if err != nil {
+		if ctx.Err() == nil {
+			c.failed(ctx, "Could not read from the response cache, skipping it", err)
+		}
+		return entry, false
+	}
+	if !ok {
+		return entry, false
+	}
+	if err := json.Unmarshal(value, &entry); err != nil {
+		c.logger.FromContext(ctx).Warn("Ignoring a corrupt cache entry", "error", err)
+		return entry, false
+	}
+	if c.now().Sub(entry.FetchedAt) >= c.maxAge {
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+func (c *responseCache) set(ctx context.Context, key string, entry cacheEntry) {
+	ttl := c.maxAge - c.now().Sub(entry.FetchedAt)
+	if ttl <= 0 || !c.available() {
+		return
+	}
+	value, err := json.Marshal(entry)
+	if err != nil {
+		c.logger.FromContext(ctx).Warn("Could not encode the cache entry", "error", err)
+		return
+	}
+	if err := c.backend.Set(ctx, key, value, ttl); err != nil && ctx.Err() == nil {
+		c.failed(ctx, "Could not write to the response cache, skipping it", err)
+	}
+}
+
+func (c *responseCache) close() error {
+	return c.backend.Close()
+}
+
+// refreshState tracks the background refreshes of stale cache entries
+type refreshState struct {
+	mu      sync.Mutex
+	running map[string]bool
+	// failed holds the last failed refresh of a key, until it succeeds or the cache entry
+	// it refreshed expires
+	failed map[string]refreshFailure
+}
+
+// refreshFailure is the error of a failed refresh and when the stale entry expires
+type refreshFailure struct {
+	err     error
+	expires time.Time
+}
+
+func newRefreshState() *refreshState {
+	return &refreshState{running: map[string]bool{}, failed: map[string]refreshFailure{}}
+}
+
+// begin marks a refresh of key as running, false if one is already running
+func (r *refreshState) begin(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running[key] {
+		return false
+	}
+	r.running[key] = true
+	return true
+}
+
+// end marks the refresh of key as done. A failure is kept until expires, when the stale
+// entry is gone from the cache. Failures of expired entries are dropped.
+func (r *refreshState) end(key string, err error, expires, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.running, key)
+	for k, failure := range r.failed {
+		if !failure.expires.After(now) {
+			delete(r.failed, k)
+		}
+	}
+	if err != nil && expires.After(now) {
+		r.failed[key] = refreshFailure{err: err, expires: expires}
+	} else {
+		delete(r.failed, key)
+	}
+}
+
+// lastError returns the error of the last refresh of key, nil if it succeeded or the entry
+// it refreshed has expired
+func (r *refreshState) lastError(key string, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	failure, ok := r.failed[key]
+	if !ok || !failure.expires.After(now) {
+		return nil
+	}
+	return failure.err
+}
+
+// cacheKey identifies an upstream response across datasources and Grafana replicas that
+// share a cache backend. The API key is not part of it, every key returns the same data.
+// Parameters are normalized, so "Marburg " and "marburg" share an entry.
+func cacheKey(apiRoot string, endpoint Endpoint, params url.Values) string {
+	normalized := url.Values{}
+	for name, values := range params {
+		for _, v := range values {
+			v = strings.TrimSpace(v)
+			if name == "q" {
+				v = strings.ToLower(v)
+			}
+			normalized.Add(name, v)
+		}
+	}
+	return apiRoot + "/" + string(endpoint) + "?" + normalized.Encode()
+}
+
+// fetchCached answers a call from the response cache while it is fresh. Within the stale
+// window the cached response is answered right away and refreshed in the background;
+// while OpenWeather fails it stays in use until the stale window ends. Stale answers
+// carry a notice with the age of the data.
+func (d *Datasource) fetchCached(ctx context.Context, endpoint Endpoint, params url.Values, out interface{}) error {
+	ttl := d.settings.CacheTTL()
+	if ttl <= 0 {
+		body, err := d.fetchEndpoint(ctx, endpoint, params)
+		if err != nil {
+			return err
+		}
+		return d.decode(ctx, endpoint, body, out)
+	}
+
+	key := cacheKey(endpoint.root(d.settings), endpoint, params)
+	entry, ok := d.cache.get(ctx, key)
+	if !ok {
+		d.tracer.AddEvent(ctx, instrumentation.EventCacheMiss, attribute.String("endpoint", string(endpoint)))
+		return d.fetchAndStore(ctx, endpoint, params, key, out)
+	}
+
+	age := d.cache.now().Sub(entry.FetchedAt)
+	stale := age >= ttl
+	d.tracer.AddEvent(ctx, instrumentation.EventCacheHit,
+		attribute.String("endpoint", string(endpoint)),
+		attribute.Bool("stale", stale),
+		attribute.Int64("age_seconds", int64(age.Seconds())))
+	if err := d.decode(ctx, endpoint, entry.Body, out); err != nil {
+		return err
+	}
+
+	executed, _ := endpointURL(d.settings, endpoint, params)
+	queryTraceFrom(ctx).addCall(upstreamCall{
+		endpoint:  endpoint,
+		url:       d.redactor.String(executed),
+		bytes:     len(entry.Body),
+		cacheHit:  true,
+		fetchedAt: entry.FetchedAt,
+	})
+	if stale {
+		queryTraceFrom(ctx).addNotice(staleNotice(age, d.refreshes.lastError(key, d.cache.now())))
+		d.refreshInBackground(ctx, endpoint, params, key, entry.FetchedAt.Add(d.cache.maxAge))
+	}
+	return nil
+}
+
+// fetchAndStore calls the endpoint, decodes the response into out and caches it
+func (d *Datasource) fetchAndStore(ctx context.Context, endpoint Endpoint, params url.Values, key string, out interface{}) error {
+	fetchedAt := d.cache.now()
+	body, err := d.fetchEndpoint(ctx, endpoint, params)
+	if err != nil {
+		return err
+	}
+	if err := d.decode(ctx, endpoint, body, out); err != nil {
+		return err
+	}
+	d.cache.set(ctx, key, cacheEntry{Body: body, FetchedAt: fetchedAt})
+	return nil
+}
+
+// refreshInBackground refreshes a stale cache entry, unless a refresh is already running.
+// The refresh outlives the query, but not the datasource instance. expires is when the
+// stale entry leaves the cache.
+func (d *Datasource) refreshInBackground(ctx context.Context, endpoint Endpoint, params url.Values, key string, expires time.Time) {
+	if !d.refreshes.begin(key) {
+		return
+	}
+
+	// Keep the trace and log attributes of the query, but neither its cancellation nor
+	// its query trace, which is complete once the query returns
+	ctx = context.WithValue(context.WithoutCancel(ctx), queryTraceKey{}, (*queryTrace)(nil))
+	ctx, done, err := d.start(ctx)
+	if err != nil {
+		d.refreshes.end(key, err, expires, d.cache.now())
+		return
+	}
+
+	go func() {
+		defer done()
+		var out json.RawMessage
+		err := d.fetchAndStore(ctx, endpoint, params, key, &out)
+		if err != nil {
+			d.logger.FromContext(ctx).Warn("Background refresh of a stale cache entry failed", "endpoint", endpoint, "error", err)
+		}
+		d.refreshes.end(key, err, expires, d.cache.now())
+	}()
+}
+
+// decode unmarshals the body of a response of endpoint into out
+func (d *Datasource) decode(ctx context.Context, endpoint Endpoint, body []byte, out interface{}) error {
+	if err := json.Unmarshal(body, out); err != nil {
+		d.logger.FromContext(ctx).Error("Error unmarshalling response", "error", err, "body", string(body))
+		return d.redactError(newPayloadError(endpoint, "error unmarshalling response", err))
+	}
+	return nil
+}
+
+// staleNotice tells the user how old cached data is and why it is shown
+func staleNotice(age time.Duration, refreshErr error) data.Notice {
+	age = age.Round(time.Second)
+	if refreshErr != nil {
+		return data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("OpenWeather could not be reached (%v), showing cached data fetched %s ago", refreshErr, age),
+		}
+	}
+	return data.Notice{
+		Severity: data.NoticeSeverityInfo,
+		Text:     fmt.Sprintf("Showing cached data fetched %s ago, it is being refreshed in the background", age),
+	}
+}
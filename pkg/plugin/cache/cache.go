@@ -0,0 +1,19 @@
+// Package cache stores serialized upstream responses. The datasource picks one of the
+// implementations through the cacheBackend setting: Memory lives in the plugin process,
+// Disk survives restarts and Redis is shared by all Grafana replicas.
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Cache is a key value store whose values expire after a TTL
+type Cache interface {
+	// Get returns the value of key. ok is false if the key is unknown or expired.
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	// Set stores value under key until ttl has passed
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Close releases the resources of the cache
+	Close() error
+}
@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// testCache is a cache implementation with a way to let time pass
+type testCache struct {
+	Cache
+	advance func(time.Duration)
+}
+
+func testCaches(t *testing.T) map[string]func(t *testing.T) testCache {
+	return map[string]func(t *testing.T) testCache{
+		"memory": func(t *testing.T) testCache {
+			m := NewMemory(10)
+			now := time.Unix(1700000000, 0)
+			m.now = func() time.Time { return now }
+			return testCache{m, func(d time.Duration) { now = now.Add(d) }}
+		},
+		"disk": func(t *testing.T) testCache {
+			d, err := NewDisk(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			now := time.Unix(1700000000, 0)
+			d.now = func() time.Time { return now }
+			return testCache{d, func(d time.Duration) { now = now.Add(d) }}
+		},
+		"redis": func(t *testing.T) testCache {
+			server := miniredis.RunT(t)
+			client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+			t.Cleanup(func() { _ = client.Close() })
+			r := NewRedis(client, "test:")
+			return testCache{r, server.FastForward}
+		},
+	}
+}
+
+func TestCaches(t *testing.T) {
+	ctx := context.Background()
+	for name, newCache := range testCaches(t) {
+		t.Run(name, func(t *testing.T) {
+			c := newCache(t)
+
+			if _, ok, err := c.Get(ctx, "missing"); ok || err != nil {
+				t.Errorf("expected a miss for an unknown key, got %v %v", ok, err)
+			}
+
+			key := "https://api.openweathermap.org/weather?q=marburg&units=metric"
+			if err := c.Set(ctx, key, []byte(`{"name":"Marburg"}`), time.Minute); err != nil {
+				t.Fatal(err)
+			}
+			value, ok, err := c.Get(ctx, key)
+			if err != nil || !ok || string(value) != `{"name":"Marburg"}` {
+				t.Errorf("expected the stored value, got %q %v %v", value, ok, err)
+			}
+
+			if err := c.Set(ctx, key, []byte(`{"name":"Gießen"}`), time.Minute); err != nil {
+				t.Fatal(err)
+			}
+			if value, _, _ := c.Get(ctx, key); string(value) != `{"name":"Gießen"}` {
+				t.Errorf("expected the value to be replaced, got %q", value)
+			}
+
+			c.advance(time.Minute)
+			if _, ok, err := c.Get(ctx, key); ok || err != nil {
+				t.Errorf("expected the value to expire, got %v %v", ok, err)
+			}
+		})
+	}
+}
+
+func TestMemoryEviction(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(10)
+	now := time.Unix(1700000000, 0)
+	m.now = func() time.Time { return now }
+
+	for i := 0; i < 10; i++ {
+		_ = m.Set(ctx, fmt.Sprint(i), nil, time.Hour+time.Duration(i)*time.Second)
+	}
+	_ = m.Set(ctx, "new", nil, time.Hour)
+	if _, ok, _ := m.Get(ctx, "0"); ok {
+		t.Error("expected the entry that expires first to be evicted")
+	}
+	if _, ok, _ := m.Get(ctx, "new"); !ok || len(m.entries) != 10 {
+		t.Errorf("expected the cache to stay at 10 entries, got %d", len(m.entries))
+	}
+}
+
+func TestDiskSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	d, err := NewDisk(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = d.Set(ctx, "kept", []byte("payload"), time.Hour)
+	_ = d.Set(ctx, "expired", []byte("payload"), time.Millisecond)
+	if err := os.WriteFile(filepath.Join(dir, "corrupt.json"), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	d, err = NewDisk(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, ok, _ := d.Get(ctx, "kept"); !ok || string(value) != "payload" {
+		t.Errorf("expected the entry to survive a restart, got %q %v", value, ok)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("expected expired and corrupt files to be pruned, got %d files", len(files))
+	}
+}
+
+func TestDiskTreatsCorruptFilesAsMisses(t *testing.T) {
+	ctx := context.Background()
+	d, err := NewDisk(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(d.path("key"), []byte(`{"key": "key", "value": "cGF5`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, ok, err := d.Get(ctx, "key"); err != nil || ok || value != nil {
+		t.Fatalf("expected a miss, got %q %v %v", value, ok, err)
+	}
+	if _, err := os.Stat(d.path("key")); !os.IsNotExist(err) {
+		t.Errorf("expected the corrupt file to be removed, got %v", err)
+	}
+	if err := d.Set(ctx, "key", []byte("payload"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if value, ok, _ := d.Get(ctx, "key"); !ok || string(value) != "payload" {
+		t.Errorf("expected the entry to be written again, got %q %v", value, ok)
+	}
+}
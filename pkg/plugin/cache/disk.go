@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// diskEntry is the content of a cache file
+type diskEntry struct {
+	Key       string    `json:"key"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Value     []byte    `json:"value"`
+}
+
+// errCorrupt is returned by read for a file that is not a cache entry, like one that was
+// only partially written before a crash
+var errCorrupt = errors.New("corrupt cache file")
+
+// Disk keeps every value in a file below dir, so cached responses survive restarts of
+// the plugin and Grafana upgrades
+type Disk struct {
+	dir string
+	now func() time.Time
+}
+
+var _ Cache = (*Disk)(nil)
+
+// NewDisk creates dir if needed and removes the expired files left behind in it
+func NewDisk(dir string) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("could not create cache directory: %w", err)
+	}
+	d := &Disk{dir: dir, now: time.Now}
+	d.prune()
+	return d, nil
+}
+
+// path returns the file of key. Keys are hashed, they may contain any character.
+func (d *Disk) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (d *Disk) Get(_ context.Context, key string) ([]byte, bool, error) {
+	entry, err := d.read(d.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	if errors.Is(err, errCorrupt) {
+		// The entry is lost either way, a miss lets it be written again
+		_ = os.Remove(d.path(key))
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if entry.Key != key {
+		return nil, false, nil
+	}
+	if !d.now().Before(entry.ExpiresAt) {
+		_ = os.Remove(d.path(key))
+		return nil, false, nil
+	}
+	return entry.Value, true, nil
+}
+
+func (d *Disk) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	content, err := json.Marshal(diskEntry{Key: key, ExpiresAt: d.now().Add(ttl), Value: value})
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so readers never see a partial entry
+	tmp, err := os.CreateTemp(d.dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("could not write cache file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write cache file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not write cache file: %w", err)
+	}
+	return os.Rename(tmp.Name(), d.path(key))
+}
+
+func (d *Disk) read(path string) (diskEntry, error) {
+	var entry diskEntry
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return entry, err
+	}
+	if err := json.Unmarshal(content, &entry); err != nil {
+		return entry, fmt.Errorf("%w %s: %w", errCorrupt, filepath.Base(path), err)
+	}
+	return entry, nil
+}
+
+// prune removes expired and corrupt cache files
+func (d *Disk) prune() {
+	files, err := os.ReadDir(d.dir)
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(d.dir, file.Name())
+		if entry, err := d.read(path); err != nil || !d.now().Before(entry.ExpiresAt) {
+			_ = os.Remove(path)
+		}
+	}
+}
+
+func (d *Disk) Close() error {
+	return nil
+}
@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// Memory keeps up to maxEntries values in the plugin process
+type Memory struct {
+	mu         sync.Mutex
+	entries    map[string]memoryEntry
+	maxEntries int
+	now        func() time.Time
+}
+
+var _ Cache = (*Memory)(nil)
+
+func NewMemory(maxEntries int) *Memory {
+	return &Memory{entries: map[string]memoryEntry{}, maxEntries: maxEntries, now: time.Now}
+}
+
+func (m *Memory) Get(_ context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if !m.now().Before(entry.expiresAt) {
+		delete(m.entries, key)
+		return nil, false, nil
+	}
+	return entry.value, true, nil
+}
+
+func (m *Memory) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.entries[key]; !ok && len(m.entries) >= m.maxEntries {
+		m.evict()
+	}
+	m.entries[key] = memoryEntry{value: value, expiresAt: m.now().Add(ttl)}
+	return nil
+}
+
+// evict drops expired entries, or the entry that expires first if none expired
+func (m *Memory) evict() {
+	var first string
+	for key, entry := range m.entries {
+		if !m.now().Before(entry.expiresAt) {
+			delete(m.entries, key)
+			continue
+		}
+		if first == "" || entry.expiresAt.Before(m.entries[first].expiresAt) {
+			first = key
+		}
+	}
+	if len(m.entries) >= m.maxEntries {
+		delete(m.entries, first)
+	}
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
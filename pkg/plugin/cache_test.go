@@ -0,0 +1,339 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/models"
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/plugin/cache"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// newCacheTestDatasource returns a datasource whose cache runs on a fake clock, and a
+// switch to make its server fail. configure changes the settings.
+func newCacheTestDatasource(t *testing.T, configure ...func(*models.PluginSettings)) (ds *Datasource, now *time.Time, fail func(bool), requests func() int) {
+	t.Helper()
+	var failing atomic.Bool
+	server, recorded := newTestServer(t, withFailure(&failing))
+	ds = newTestDatasourceWithServer(server, configure...)
+	clock := time.Unix(1700000000, 0)
+	ds.cache.now = func() time.Time { return clock }
+	return ds, &clock, failing.Store, func() int { return len(recorded.paths()) }
+}
+
+// queryCurrent runs a current weather query that asks for the fetched_at field
+func queryCurrent(t *testing.T, ds *Datasource) backend.DataResponse {
+	t.Helper()
+	resp, err := ds.QueryData(context.Background(), queryTypeRequest(
+		backend.DataQuery{RefID: "A", QueryType: QueryTypeCurrent, JSON: []byte(`{"city": "Marburg", "fetchedAtField": true}`)},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Responses["A"]
+}
+
+// cacheNotice returns the text of the notice about cached data, empty if there is none
+func cacheNotice(res backend.DataResponse) (data.NoticeSeverity, string) {
+	for _, notice := range res.Frames[0].Meta.Notices {
+		if strings.Contains(notice.Text, "cached data") {
+			return notice.Severity, notice.Text
+		}
+	}
+	return 0, ""
+}
+
+// fetchedAt returns the fetched_at field of the first row of the response, zero if the
+// frame has none
+func fetchedAt(t *testing.T, res backend.DataResponse) time.Time {
+	t.Helper()
+	field, _ := res.Frames[0].FieldByName("fetched_at")
+	if field == nil || field.Len() == 0 {
+		return time.Time{}
+	}
+	return field.At(0).(time.Time)
+}
+
+func TestFreshResponsesAreCached(t *testing.T) {
+	ds, now, _, requests := newCacheTestDatasource(t)
+
+	queryCurrent(t, ds)
+	*now = now.Add(time.Minute)
+	res := queryCurrent(t, ds)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if requests() != 1 {
+		t.Errorf("expected the second query to be answered from the cache, got %d requests", requests())
+	}
+	if hits, _ := queryStat(res.Frames[0], "Cache hits"); hits != 1 {
+		t.Errorf("expected a cache hit, got %v", hits)
+	}
+	if _, text := cacheNotice(res); text != "" {
+		t.Errorf("expected no notice for fresh data, got %q", text)
+	}
+	want := time.Unix(1700000000, 0).UTC()
+	if got := fetchedAt(t, res); !got.Equal(want) {
+		t.Errorf("expected a fetched_at field of %s, got %s", want, got)
+	}
+	custom := res.Frames[0].Meta.Custom.(map[string]interface{})
+	if custom["fetched_at"] != want.Format(time.RFC3339) {
+		t.Errorf("expected fetched_at %s, got %v", want, custom["fetched_at"])
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	ds, now, _, requests := newCacheTestDatasource(t)
+
+	queryCurrent(t, ds)
+	*now = now.Add(ds.settings.CacheTTL() + time.Minute)
+	res := queryCurrent(t, ds)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	severity, text := cacheNotice(res)
+	if severity != data.NoticeSeverityInfo || !strings.Contains(text, "fetched 6m0s ago") {
+		t.Errorf("expected a notice about the age of the data, got %q", text)
+	}
+
+	ds.inflight.Wait()
+	if requests() != 2 {
+		t.Errorf("expected a background refresh, got %d requests", requests())
+	}
+	res = queryCurrent(t, ds)
+	if _, text := cacheNotice(res); text != "" || requests() != 2 {
+		t.Errorf("expected the refreshed data to be fresh, got %q after %d requests", text, requests())
+	}
+	if got := fetchedAt(t, res); !got.Equal(*now) {
+		t.Errorf("expected the time of the refresh as fetched_at, got %s", got)
+	}
+}
+
+func TestZeroStaleWindowDisablesStaleResponses(t *testing.T) {
+	ds, now, fail, requests := newCacheTestDatasource(t, func(s *models.PluginSettings) {
+		s.CacheStaleSeconds = 0
+	})
+
+	queryCurrent(t, ds)
+	*now = now.Add(ds.settings.CacheTTL())
+	res := queryCurrent(t, ds)
+	if _, text := cacheNotice(res); res.Error != nil || text != "" || requests() != 2 {
+		t.Errorf("expected expired data to be fetched again, got %v %q after %d requests", res.Error, text, requests())
+	}
+
+	fail(true)
+	*now = now.Add(ds.settings.CacheTTL())
+	if res := queryCurrent(t, ds); res.Error == nil {
+		t.Error("expected the error instead of stale data")
+	}
+}
+
+func TestStaleDataIsServedOnErrors(t *testing.T) {
+	ds, now, fail, _ := newCacheTestDatasource(t)
+
+	queryCurrent(t, ds)
+	fail(true)
+	*now = now.Add(ds.settings.CacheTTL())
+	if res := queryCurrent(t, ds); res.Error != nil {
+		t.Fatalf("expected the stale data, got %v", res.Error)
+	}
+	ds.inflight.Wait()
+
+	res := queryCurrent(t, ds)
+	severity, text := cacheNotice(res)
+	if res.Error != nil || severity != data.NoticeSeverityWarning || !strings.Contains(text, "could not be reached") {
+		t.Errorf("expected the stale data with a warning, got %v %q", res.Error, text)
+	}
+	ds.inflight.Wait()
+
+	// Once the stale window passed, the error is returned
+	*now = now.Add(ds.settings.CacheStale())
+	if res := queryCurrent(t, ds); res.Error == nil {
+		t.Error("expected an error once the stale window passed")
+	}
+}
+
+func TestFailedRefreshesAreForgotten(t *testing.T) {
+	refreshes := newRefreshState()
+	now := time.Unix(1700000000, 0)
+	failure := errors.New("unavailable")
+
+	refreshes.begin("a")
+	refreshes.end("a", failure, now.Add(time.Minute), now)
+	refreshes.begin("b")
+	refreshes.end("b", failure, now.Add(time.Hour), now)
+	if err := refreshes.lastError("a", now); err != failure {
+		t.Errorf("expected the failure of the refresh, got %v", err)
+	}
+
+	// A successful refresh forgets the failure
+	refreshes.begin("b")
+	refreshes.end("b", nil, now.Add(time.Hour), now)
+	if err := refreshes.lastError("b", now); err != nil {
+		t.Errorf("expected no failure after a successful refresh, got %v", err)
+	}
+
+	// Failures of entries that expired are dropped by the next refresh
+	now = now.Add(2 * time.Minute)
+	if err := refreshes.lastError("a", now); err != nil {
+		t.Errorf("expected no failure of an expired entry, got %v", err)
+	}
+	refreshes.begin("c")
+	refreshes.end("c", nil, now.Add(time.Hour), now)
+	if len(refreshes.failed) != 0 {
+		t.Errorf("expected the failures to be dropped, got %v", refreshes.failed)
+	}
+}
+
+func TestCacheKeyNormalizesParameters(t *testing.T) {
+	a := cacheKey("https://api.openweathermap.org", EndpointWeather, url.Values{"q": {" Marburg"}, "units": {"metric"}})
+	b := cacheKey("https://api.openweathermap.org", EndpointWeather, url.Values{"units": {"metric"}, "q": {"marburg "}})
+	if a != b {
+		t.Errorf("expected the same key, got %q and %q", a, b)
+	}
+	if c := cacheKey("http://localhost:8080", EndpointWeather, url.Values{"q": {"marburg"}, "units": {"metric"}}); c == a {
+		t.Error("expected different API roots to use different keys")
+	}
+}
+
+func TestRedisCacheIsSharedByReplicas(t *testing.T) {
+	redisServer := miniredis.RunT(t)
+	server, requests := newTestServer(t)
+
+	// Two datasource instances stand in for two Grafana replicas
+	replica := func() *Datasource {
+		ds := newTestDatasourceWithServer(server, func(s *models.PluginSettings) {
+			s.CacheBackend = models.CacheBackendRedis
+			s.RedisAddress = redisServer.Addr()
+		})
+		t.Cleanup(ds.Dispose)
+		return ds
+	}
+	first, second := replica(), replica()
+
+	if res := queryCurrent(t, first); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	res := queryCurrent(t, second)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if n := len(requests.paths()); n != 1 {
+		t.Errorf("expected the second replica to be answered from Redis, got %d requests", n)
+	}
+	if n, _ := queryStat(res.Frames[0], "Cache hits"); n != 1 {
+		t.Errorf("expected a cache hit, got %v", n)
+	}
+}
+
+func TestRedisCacheFailureFallsThrough(t *testing.T) {
+	redisServer := miniredis.RunT(t)
+	server, _ := newTestServer(t)
+	ds := newTestDatasourceWithServer(server, func(s *models.PluginSettings) {
+		s.CacheBackend = models.CacheBackendRedis
+		s.RedisAddress = redisServer.Addr()
+	})
+	t.Cleanup(ds.Dispose)
+	redisServer.Close()
+
+	if res := queryCurrent(t, ds); res.Error != nil {
+		t.Errorf("expected the query to succeed without the cache, got %v", res.Error)
+	}
+}
+
+// newUnresponsiveServer starts a server that accepts connections but never answers, like a
+// hanging Redis server. conns returns the number of accepted connections.
+func newUnresponsiveServer(t *testing.T) (addr string, conns func() int32) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	var count int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&count, 1)
+			t.Cleanup(func() { _ = conn.Close() })
+		}
+	}()
+	return listener.Addr().String(), func() int32 { return atomic.LoadInt32(&count) }
+}
+
+func TestUnresponsiveRedisCacheIsSkipped(t *testing.T) {
+	redisAddr, conns := newUnresponsiveServer(t)
+	server, requests := newTestServer(t)
+	ds := newTestDatasourceWithServer(server, func(s *models.PluginSettings) {
+		s.CacheBackend = models.CacheBackendRedis
+		s.RedisAddress = redisAddr
+	})
+	t.Cleanup(ds.Dispose)
+
+	start := time.Now()
+	if res := queryCurrent(t, ds); res.Error != nil {
+		t.Fatalf("expected the query to succeed without the cache, got %v", res.Error)
+	}
+	if elapsed := time.Since(start); elapsed > 4*redisTimeout {
+		t.Errorf("expected the cache to give up after %s, the query took %s", redisTimeout, elapsed)
+	}
+
+	// Within the backoff the cache is not asked again
+	before := conns()
+	start = time.Now()
+	if res := queryCurrent(t, ds); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if elapsed := time.Since(start); elapsed > redisTimeout {
+		t.Errorf("expected the cache to be skipped, the query took %s", elapsed)
+	}
+	if n, calls := conns(), len(requests.paths()); n != before || calls != 2 {
+		t.Errorf("expected both queries to reach OpenWeather without Redis, got %d connections and %d calls", n-before, calls)
+	}
+}
+
+// flakyCache is a cache backend that fails while broken is set and counts its calls
+type flakyCache struct {
+	cache.Cache
+	broken bool
+	calls  int
+}
+
+func (c *flakyCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	c.calls++
+	if c.broken {
+		return nil, false, errors.New("connection refused")
+	}
+	return c.Cache.Get(ctx, key)
+}
+
+func TestCacheBackendIsRetriedAfterBackoff(t *testing.T) {
+	backend := &flakyCache{Cache: cache.NewMemory(10), broken: true}
+	ds, now, _, requests := newCacheTestDatasource(t)
+	ds.cache.backend = backend
+
+	queryCurrent(t, ds)
+	queryCurrent(t, ds)
+	if backend.calls != 1 || requests() != 2 {
+		t.Errorf("expected the failed cache to be skipped, got %d cache calls and %d requests", backend.calls, requests())
+	}
+
+	backend.broken = false
+	*now = now.Add(cacheBackoff)
+	queryCurrent(t, ds)
+	queryCurrent(t, ds)
+	if backend.calls != 3 || requests() != 3 {
+		t.Errorf("expected the cache to be used again after the backoff, got %d cache calls and %d requests", backend.calls, requests())
+	}
+}
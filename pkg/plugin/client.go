@@ -0,0 +1,199 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/plugin/instrumentation"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// retryBackoff is the wait before the first retry of an upstream call, it grows with
+// every further retry. The number of retries is configured by MaxRetries.
+const retryBackoff = 250 * time.Millisecond
+
+// fetchEndpoint sends a GET request to an OpenWeather endpoint and returns the body of the
+// response. Calls are spread across the API keys of the datasource that are entitled to the
+// endpoint and within their rate limits; a key that is rejected or rate limited is replaced
+// by another available key right away. Failures of the call are returned as *UpstreamError with secrets redacted.
+// The call is recorded in the query trace of ctx for the query inspector.
+func (d *Datasource) fetchEndpoint(ctx context.Context, endpoint Endpoint, params url.Values) (body []byte, err error) {
+	logger := d.logger.FromContext(ctx)
+	defer func() {
+		err = d.redactError(err)
+	}()
+
+	// Validate API key
+	keys := d.apiKeys()
+	if keys.size() == 0 {
+		logger.Error("API key is missing")
+		return nil, &UpstreamError{
+			Kind:     ErrUnauthorized,
+			Endpoint: endpoint,
+			Message:  "missing API key: please add a valid OpenWeather API key in the datasource configuration",
+		}
+	}
+
+	// Skip keys that are known not to include the endpoint in their plan
+	entitled := func(k *pooledKey) bool { return d.plans.entitled(k.id, endpoint) }
+	key := keys.pick(entitled)
+	if key == nil {
+		return nil, newNotInPlanError(endpoint, 0)
+	}
+
+	call := upstreamCall{endpoint: endpoint}
+	start := time.Now()
+	defer func() {
+		call.duration = time.Since(start)
+		queryTraceFrom(ctx).addCall(call)
+	}()
+
+	for retries, failovers := 0, 0; ; {
+		query := url.Values{}
+		for k, v := range params {
+			query[k] = v
+		}
+		query.Set("appid", key.key)
+
+		requestURL, err := endpointURL(d.settings, endpoint, query)
+		if err != nil {
+			logger.Error("Error building request URL", "error", err)
+			return nil, err
+		}
+		call.url = d.redactor.String(requestURL)
+
+		// A key over its rate limit is not reported, the limiter knows when it frees up
+		if err = d.limiter.allow(ctx, endpoint, key); err == nil {
+			body, err = d.do(ctx, endpoint, requestURL)
+			err = d.classifyUnauthorized(ctx, keys, key, endpoint, err)
+			keys.report(key, err)
+		}
+		if err == nil {
+			d.plans.record(key.id, endpoint, true)
+			call.fetchedAt = start
+			break
+		}
+
+		if (errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrRateLimited) || errors.Is(err, ErrNotInPlan)) &&
+			failovers < keys.size()-1 && keys.available(key, entitled) {
+			failovers++
+			call.retries++
+			logger.Warn("API key was rejected, failing over to another key", "endpoint", endpoint, "keyId", key.id, "error", err)
+			d.tracer.AddEvent(ctx, instrumentation.EventRetry,
+				attribute.String("endpoint", string(endpoint)),
+				attribute.String("reason", "failover"),
+				attribute.String("key_id", key.id),
+				attribute.String("error", err.Error()))
+			key = keys.pick(entitled)
+			continue
+		}
+
+		if retries >= d.settings.MaxRetries || !retryable(err) {
+			call.bytes = len(body)
+			return nil, err
+		}
+
+		retries++
+		call.retries++
+		logger.Warn("Retrying upstream request", "endpoint", endpoint, "attempt", retries, "error", err)
+		d.tracer.AddEvent(ctx, instrumentation.EventRetry,
+			attribute.String("endpoint", string(endpoint)),
+			attribute.Int("attempt", retries),
+			attribute.String("error", err.Error()))
+		select {
+		case <-ctx.Done():
+			return nil, newTransportError(endpoint, ctx.Err())
+		case <-time.After(retryBackoff * time.Duration(retries)):
+		}
+	}
+	call.bytes = len(body)
+	return body, nil
+}
+
+// do sends a single request behind the circuit breaker of endpoint and records it in the
+// upstream metrics. It returns the body of a successful response.
+func (d *Datasource) do(ctx context.Context, endpoint Endpoint, requestURL string) (body []byte, err error) {
+	// Fail fast while the endpoint is known to be down
+	breaker := d.breakers[endpoint]
+	if err := breaker.allow(); err != nil {
+		d.logger.FromContext(ctx).SampledDebug("Circuit breaker rejected request", "endpoint", endpoint)
+		return nil, err
+	}
+	defer func() {
+		breaker.record(err)
+	}()
+	return d.send(ctx, endpoint, requestURL, d.metrics.StartUpstreamRequest(ctx, string(endpoint)))
+}
+
+// send sends a single request and returns the body of a successful response. recordCall
+// is called with the status code and size of the response, 0 if none was received.
+func (d *Datasource) send(ctx context.Context, endpoint Endpoint, requestURL string, recordCall func(statusCode int, size int)) (body []byte, err error) {
+	ctx, span := d.tracer.StartSpan(ctx, "upstream_request",
+		attribute.String("endpoint", string(endpoint)),
+		attribute.String("url", d.redactor.String(requestURL)))
+	defer func() {
+		d.tracer.RecordError(ctx, err)
+		span.End()
+	}()
+
+	logger := d.logger.FromContext(ctx)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		recordCall(0, 0)
+		logger.Error("Error creating request", "error", err)
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+
+	// Add additional request headers
+	req.Header.Add("Accept", "application/json")
+
+	logger.SampledDebug("Sending request to OpenWeather API",
+		"endpoint", endpoint,
+		"url_without_key", d.redactor.String(requestURL))
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		recordCall(0, 0)
+		logger.Error("Error making request", "error", err)
+		return nil, newTransportError(endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+
+	body, err = io.ReadAll(resp.Body)
+	recordCall(resp.StatusCode, len(body))
+	if err != nil {
+		logger.Error("Error reading response", "error", err)
+		return body, newTransportError(endpoint, err)
+	}
+
+	// Enhanced error handling
+	if resp.StatusCode != http.StatusOK {
+		errorMsg := string(body)
+		logger.Error("API returned error",
+			"endpoint", endpoint,
+			"status", resp.StatusCode,
+			"body", errorMsg)
+
+		return body, newStatusError(endpoint, resp.StatusCode, errorMsg)
+	}
+	return body, nil
+}
+
+// retryable reports whether a failed call may succeed when it is sent again. Calls
+// rejected by an open circuit breaker are not retried, and neither are timeouts, whose
+// retry would keep the query waiting as long again.
+func retryable(err error) bool {
+	var upstreamErr *UpstreamError
+	if !errors.Is(err, ErrUpstreamUnavailable) || errors.Is(err, errCircuitOpen) {
+		return false
+	}
+	return !errors.As(err, &upstreamErr) || upstreamErr.Status() != backend.StatusTimeout
+}
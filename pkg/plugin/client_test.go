@@ -0,0 +1,50 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestUpstreamRetries(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		maxRetries int
+		status     int
+		delay      time.Duration
+		want       int
+	}{
+		{"no retries by default", 0, http.StatusServiceUnavailable, 0, 1},
+		{"configured retries", 2, http.StatusServiceUnavailable, 0, 3},
+		{"gateway timeouts are not retried", 2, http.StatusGatewayTimeout, 0, 1},
+		{"client timeouts are not retried", 2, http.StatusOK, 2 * time.Second, 1},
+		{"client errors are not retried", 2, http.StatusNotFound, 0, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server, requests := newTestServer(t, withDelay(tc.delay), withStatus(map[string]int{"/data/2.5/weather": tc.status}))
+			ds := newTestDatasourceWithSettings(func(s *models.PluginSettings) {
+				s.APIRoot = server.URL
+				s.Secrets.ApiKey = "test-key"
+				s.MaxRetries = tc.maxRetries
+			})
+			ds.httpClient.Timeout = time.Second
+
+			resp, err := ds.QueryData(context.Background(), queryTypeRequest(
+				backend.DataQuery{RefID: "A", QueryType: QueryTypeCurrent, JSON: []byte(`{"city": "Marburg"}`)},
+			))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Responses["A"].Error == nil {
+				t.Error("expected the query to fail")
+			}
+			if n := len(requests.paths()); n != tc.want {
+				t.Errorf("expected %d requests, got %d", tc.want, n)
+			}
+		})
+	}
+}
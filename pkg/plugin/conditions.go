@@ -0,0 +1,131 @@
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Condition groups as documented by OpenWeather for the weather condition codes
+// https://openweathermap.org/weather-conditions
+const (
+	conditionThunderstorm = "thunderstorm"
+	conditionDrizzle      = "drizzle"
+	conditionRain         = "rain"
+	conditionSnow         = "snow"
+	conditionAtmosphere   = "atmosphere"
+	conditionClear        = "clear"
+	conditionClouds       = "clouds"
+	conditionUnknown      = "unknown"
+)
+
+const iconURLTemplate = "https://openweathermap.org/img/wn/%s@2x.png"
+
+// conditionGroup maps an OpenWeather condition code to its condition group
+func conditionGroup(id int) string {
+	switch {
+	case id >= 200 && id < 300:
+		return conditionThunderstorm
+	case id >= 300 && id < 400:
+		return conditionDrizzle
+	case id >= 500 && id < 600:
+		return conditionRain
+	case id >= 600 && id < 700:
+		return conditionSnow
+	case id >= 700 && id < 800:
+		return conditionAtmosphere
+	case id == 800:
+		return conditionClear
+	case id > 800 && id < 900:
+		return conditionClouds
+	default:
+		return conditionUnknown
+	}
+}
+
+// isPrecipitation reports whether the condition group brings any precipitation
+func isPrecipitation(group string) bool {
+	switch group {
+	case conditionThunderstorm, conditionDrizzle, conditionRain, conditionSnow:
+		return true
+	}
+	return false
+}
+
+// iconURL returns the URL of the OpenWeather icon, or an empty string if no icon is set
+func iconURL(icon string) string {
+	if icon == "" {
+		return ""
+	}
+	return fmt.Sprintf(iconURLTemplate, icon)
+}
+
+// conditionColumns collects the condition fields for every item of a forecast
+type conditionColumns struct {
+	ids           []int64
+	groups        []string
+	icons         []string
+	thunderstorm  []bool
+	precipitation []bool
+	rain          []bool
+	snow          []bool
+}
+
+func (c *conditionColumns) append(item ForecastItem) {
+	var w Weather
+	if len(item.Weather) > 0 {
+		w = item.Weather[0]
+	}
+
+	group := conditionGroup(w.ID)
+	c.ids = append(c.ids, int64(w.ID))
+	c.groups = append(c.groups, group)
+	c.icons = append(c.icons, iconURL(w.Icon))
+	c.thunderstorm = append(c.thunderstorm, group == conditionThunderstorm)
+	c.precipitation = append(c.precipitation, isPrecipitation(group))
+	c.rain = append(c.rain, group == conditionRain || group == conditionDrizzle || group == conditionThunderstorm)
+	c.snow = append(c.snow, group == conditionSnow)
+}
+
+// fields builds the frame fields including the value mappings used by tables
+func (c *conditionColumns) fields() []*data.Field {
+	groupField := data.NewField("condition", nil, c.groups)
+	groupField.Config = &data.FieldConfig{
+		DisplayName: "Condition",
+		Mappings:    conditionMappings(),
+	}
+
+	iconField := data.NewField("icon", nil, c.icons)
+	iconField.Config = &data.FieldConfig{
+		DisplayName: "Icon",
+		Custom: map[string]interface{}{
+			"cellOptions": map[string]interface{}{"type": "image"},
+		},
+	}
+
+	return []*data.Field{
+		data.NewField("condition_id", nil, c.ids),
+		groupField,
+		iconField,
+		data.NewField("thunderstorm", nil, c.thunderstorm),
+		data.NewField("precipitation", nil, c.precipitation),
+		data.NewField("rain_expected", nil, c.rain),
+		data.NewField("snow_expected", nil, c.snow),
+	}
+}
+
+// conditionMappings returns the display text and color for each condition group
+func conditionMappings() data.ValueMappings {
+	return data.ValueMappings{
+		data.ValueMapper{
+			conditionThunderstorm: {Text: "Thunderstorm", Color: "purple", Index: 0},
+			conditionDrizzle:      {Text: "Drizzle", Color: "light-blue", Index: 1},
+			conditionRain:         {Text: "Rain", Color: "blue", Index: 2},
+			conditionSnow:         {Text: "Snow", Color: "super-light-blue", Index: 3},
+			conditionAtmosphere:   {Text: "Atmosphere", Color: "gray", Index: 4},
+			conditionClear:        {Text: "Clear", Color: "yellow", Index: 5},
+			conditionClouds:       {Text: "Clouds", Color: "light-gray", Index: 6},
+			conditionUnknown:      {Text: "Unknown", Color: "transparent", Index: 7},
+		},
+	}
+}
@@ -4,21 +4,30 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/models" /* meine repository */
 	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/plugin/instrumentation"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
-	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/redis/go-redis/v9"
 	"go.opentelemetry.io/otel/attribute"
 )
 
+// PluginID is the ID of the plugin as registered in plugin.json
+const PluginID = "grafana-openweather-datasource"
+
+// forecastStep is the interval between two items of the 5 day forecast
+const forecastStep = 3 * time.Hour
+
 // Make sure Datasource implements required interfaces. This is important to do
 // since otherwise we will only get a not implemented error response from plugin in
 // runtime. In this example datasource instance implements backend.QueryDataHandler,
@@ -30,12 +39,40 @@ var (
 	_ instancemgmt.InstanceDisposer = (*Datasource)(nil)
 )
 
-// Datasource struct with baseURL and logger
+// Datasource struct with settings and logger
 type Datasource struct {
-	baseURL string
-	logger  log.Logger
-	tracer  *instrumentation.TracingHelper
-	metrics *instrumentation.Metrics
+	settings   *models.PluginSettings
+	logger     *instrumentation.Logger
+	tracer     *instrumentation.TracingHelper
+	metrics    *instrumentation.Metrics
+	redactor   *instrumentation.Redactor
+	httpClient *http.Client
+	mux        *datasource.QueryTypeMux
+
+	// keys spreads upstream calls across the API keys. It is replaced when the key file
+	// changes, keyMu guards checking the file.
+	keys          atomic.Pointer[keyPool]
+	keyMu         sync.Mutex
+	keyModTime    time.Time
+	keysCheckedAt time.Time
+
+	// plans remembers the endpoints each key is entitled to
+	plans *planCache
+	// breakers holds a circuit breaker per endpoint
+	breakers map[Endpoint]*circuitBreaker
+	// cache holds upstream responses, refreshes tracks the refreshes of stale ones
+	cache     *responseCache
+	refreshes *refreshState
+	limiter   *rateLimiter
+	// redis is the client of the Redis server that the cache and the rate limiter may
+	// share, nil if neither uses it
+	redis redis.UniversalClient
+
+	// ctx lives as long as the instance, all requests and background work hang off it
+	ctx         context.Context
+	cancel      context.CancelFunc
+	lifecycleMu sync.Mutex
+	inflight    sync.WaitGroup
 }
 
 // NewDatasourceInstance creates a new datasource instance.
@@ -45,41 +82,70 @@ func NewDatasource(_ context.Context, settings backend.DataSourceInstanceSetting
 		return nil, err
 	}
 
-	logger := log.New().With("datasource", settings.Name)
-
-	// Make sure we have a proper base URL for the API
-	baseURL := config.Path
-	if baseURL == "" {
-		baseURL = "https://api.openweathermap.org/data/2.5/forecast"
-	} else if !strings.HasPrefix(baseURL, "http") {
-		// If no protocol is specified, add https
-		baseURL = "https://" + baseURL
-	}
+	logger := instrumentation.NewLogger().With("datasource", settings.Name)
 
 	// Check if API key exists
 	if config.Secrets.ApiKey == "" {
 		logger.Error("No API key provided in datasource configuration")
 	} else {
 		// Don't log the actual API key
-		logger.Info("API key found in configuration")
+		logger.Info("API key found", "source", config.Secrets.ApiKeySource, "keys", len(config.Secrets.Keys()))
 	}
 
-	logger.Info("Creating new datasource instance", "baseURL", baseURL)
+	logger.Info("Creating new datasource instance",
+		"apiRoot", config.APIRoot,
+		"schemaVersion", config.SchemaVersion)
 
-	return &Datasource{
-		baseURL: baseURL,
-		logger:  logger,
-		tracer:  instrumentation.NewTracingHelper(tracing.DefaultTracer()),
-		metrics: instrumentation.NewMetrics("openweather"),
-	}, nil
+	return newDatasource(config, logger,
+		instrumentation.NewTracingHelper(tracing.DefaultTracer()),
+		instrumentation.NewMetrics("openweather").WithDatasource(settings.UID)), nil
 }
 
-// Dispose here tells plugin SDK that plugin wants to clean up resources when a new instance
-// created. As soon as datasource settings change detected by SDK old datasource instance will
-// be disposed and a new one will be created using NewSampleDatasource factory function.
-func (d *Datasource) Dispose() {
-	d.logger.Info("Disposing datasource instance")
-	// Clean up datasource instance resources.
+// newDatasource wires a datasource for already loaded settings
+func newDatasource(config *models.PluginSettings, logger *instrumentation.Logger, tracer *instrumentation.TracingHelper, metrics *instrumentation.Metrics) *Datasource {
+	redactor := instrumentation.NewRedactor(append(config.Secrets.Keys(), config.Secrets.RedisPassword)...)
+	d := &Datasource{
+		settings:  config,
+		logger:    logger.WithRedactor(redactor),
+		tracer:    tracer.WithRedactor(redactor),
+		metrics:   metrics,
+		redactor:  redactor,
+		plans:     newPlanCache(),
+		refreshes: newRefreshState(),
+		httpClient: &http.Client{
+			Timeout: config.Timeout(),
+		},
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	if config.UsesRedis() {
+		d.redis = newRedisClient(config)
+	}
+	d.cache = newResponseCache(newCacheBackend(config, d.redis, d.logger), config.CacheTTL()+config.CacheStale(), d.logger)
+	d.limiter = newRateLimiter(config, d.redis, d.logger, metrics)
+	d.breakers = newCircuitBreakers(d.logger, metrics)
+	d.initAPIKeys()
+	d.mux = d.newQueryTypeMux()
+	return d
+}
+
+// redisTimeout bounds every Redis command. Redis is an optimization in front of OpenWeather,
+// a slow server must not hold up queries.
+const redisTimeout = 200 * time.Millisecond
+
+// newRedisClient connects to the Redis server of the settings. A comma separated address
+// connects to a cluster. Failed commands are not retried, the callers fall back instead.
+func newRedisClient(config *models.PluginSettings) redis.UniversalClient {
+	return redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs:        strings.Split(config.RedisAddress, ","),
+		Password:     config.Secrets.RedisPassword,
+		DB:           config.RedisDB,
+		DialTimeout:  redisTimeout,
+		ReadTimeout:  redisTimeout,
+		WriteTimeout: redisTimeout,
+		PoolTimeout:  redisTimeout,
+		// -1 disables retries, 0 would mean the default of 3
+		MaxRetries: -1,
+	})
 }
 
 // QueryData handles multiple queries and returns multiple responses.
@@ -88,93 +154,162 @@ func (d *Datasource) Dispose() {
 // contains Frames ([]*Frame).
 func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
 	start := time.Now()
-	response, err := d.queryData(ctx, req)
-	d.metrics.RecordRequest("query_data", start, err)
+	defer d.metrics.StartRequest()()
+
+	ctx = instrumentation.WithLogAttributes(ctx, requestLogAttributes(req.PluginContext)...)
+
+	ctx, done, err := d.start(ctx)
+	if err != nil {
+		d.metrics.RecordRequest(ctx, "query_data", start, err)
+		return nil, err
+	}
+	defer done()
+
+	response, err := d.mux.QueryData(ctx, req)
+	d.metrics.RecordRequest(ctx, "query_data", start, err)
 	return response, err
 }
 
-func (d *Datasource) queryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+// queryHandler processes a single query of one query type
+type queryHandler func(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery) backend.DataResponse
+
+// handleQueries returns a QueryDataHandler that runs handler for every query of the request
+func (d *Datasource) handleQueries(queryType string, handler queryHandler) backend.QueryDataHandlerFunc {
+	return func(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+		return d.queryData(ctx, req, queryType, handler)
+	}
+}
+
+func (d *Datasource) queryData(ctx context.Context, req *backend.QueryDataRequest, queryType string, handler queryHandler) (*backend.QueryDataResponse, error) {
 	// Create response struct
 	response := backend.NewQueryDataResponse()
 
-	// Log request details
-	d.logger.Info("Processing query data request",
-		"context", ctx,
-		"request", req.PluginContext.DataSourceInstanceSettings.Name,
-		"queries", len(req.Queries))
-
 	// Create span for request tracing
 	ctx, span := d.tracer.StartSpan(ctx, "queryData",
+		attribute.String("query_type", queryType),
 		attribute.Int("query_count", len(req.Queries)))
 	defer span.End()
 
-	// Process each query in the request
-	for _, q := range req.Queries {
-		d.logger.Debug("Processing individual query",
-			"refID", q.RefID,
-			"timeRange", q.TimeRange)
-
-		// Create query-specific span
-		_, querySpan := d.tracer.StartSpan(ctx, "process_query",
-			attribute.String("query_ref_id", q.RefID))
+	d.logger.FromContext(ctx).Debug("Processing query data request",
+		"queryType", queryType,
+		"queries", len(req.Queries))
 
-		// Process query here
-		res := d.processQuery(ctx, req.PluginContext, q)
-		response.Responses[q.RefID] = res
+	// Process the queries concurrently, bounded by the configured concurrency
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		sem = make(chan struct{}, d.maxConcurrency(len(req.Queries)))
+	)
+	for _, q := range req.Queries {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(q backend.DataQuery) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			// Create query-specific span
+			queryCtx, querySpan := d.tracer.StartSpan(ctx, "process_query",
+				attribute.String("query_ref_id", q.RefID),
+				attribute.String("query_type", q.QueryType))
+			defer querySpan.End()
+
+			queryCtx = instrumentation.WithLogAttributes(queryCtx, "refId", q.RefID)
+			d.logger.FromContext(queryCtx).Debug("Processing individual query", "timeRange", q.TimeRange)
+
+			// Invalid query JSON is reported by the handler of the query type
+			var options queryOptions
+			_ = json.Unmarshal(q.JSON, &options)
+
+			// Process query here, recording its upstream calls for the query inspector
+			queryCtx, trace := withQueryTrace(queryCtx)
+			queryStart := time.Now()
+			res := handler(queryCtx, req.PluginContext, q)
+			trace.apply(&res, options)
+			d.redactResponse(&res)
+
+			errorType := ""
+			if res.Error != nil {
+				errorType = responseErrorType(res)
+				d.tracer.RecordError(queryCtx, res.Error)
+			}
+			d.metrics.RecordOperation(queryCtx, "query", queryStart, errorType)
 
-		querySpan.End()
+			mu.Lock()
+			response.Responses[q.RefID] = res
+			mu.Unlock()
+		}(q)
 	}
+	wg.Wait()
 
+	addPartialResultNotices(response)
 	return response, nil
 }
 
-// Helper method to process individual queries
-func (d *Datasource) processQuery(_ context.Context, pCtx backend.PluginContext, query backend.DataQuery) backend.DataResponse {
+// handleForecastQuery processes a forecast query, the default query type
+func (d *Datasource) handleForecastQuery(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery) backend.DataResponse {
 	var response backend.DataResponse
-	var qm queryModel
+	logger := d.logger.FromContext(ctx)
 
-	// Decode the query JSON into our queryModel
-	err := json.Unmarshal(query.JSON, &qm)
+	// Decode the query JSON into our queryModel and migrate older query shapes
+	qm, err := parseQuery(query.JSON)
 	if err != nil {
-		d.logger.Error("Failed to parse query", "error", err)
-		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("json unmarshal: %v", err.Error()))
+		logger.Error("Failed to parse query", "error", err)
+		return backend.ErrDataResponseWithSource(backend.StatusBadRequest, backend.ErrorSourceDownstream, err.Error())
 	}
 
-	d.logger.Info("Processing query",
-		"refID", query.RefID,
-		"timeRange", query.TimeRange,
-		"queryModel", qm)
-
-	// Get API key from datasource settings
-	config, err := models.LoadPluginSettings(*pCtx.DataSourceInstanceSettings)
-	if err != nil {
-		d.logger.Error("Failed to load settings", "error", err)
-		return backend.ErrDataResponse(backend.StatusBadRequest, "Unable to load datasource settings")
+	logger.Debug("Processing forecast query",
+		"city", qm.City,
+		"metric", qm.Metric,
+		"format", qm.Format,
+		"units", qm.Units)
+
+	// Fall back to the defaults of the datasource settings
+	var notices []data.Notice
+	if qm.City == "" && d.settings.DefaultLocation != "" {
+		qm.City = d.settings.DefaultLocation
+		notices = append(notices, defaultLocationNotice(qm.City))
+	}
+	if qm.Units == "" {
+		qm.Units = d.settings.DefaultUnits
 	}
 
-	// Check if city is provided
-	if qm.City == "" {
-		d.logger.Error("City is not provided in the query")
-		return backend.ErrDataResponse(backend.StatusBadRequest, "City is required")
+	d.tracer.SetQueryAttributes(ctx, instrumentation.QueryAttributes{
+		City: qm.City, Metric: qm.Metric, Format: qm.Format, Units: qm.Units,
+	})
+	if err := qm.validate(); err != nil {
+		logger.Error("Invalid forecast query", "error", err)
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
 	}
 
 	// Fetch weather data
-	weatherData, err := d.GetHistoricalWeather(qm.City, config.Secrets.ApiKey, qm)
+	weatherData, err := d.GetHistoricalWeather(ctx, qm.City, qm)
 	if err != nil {
-		d.logger.Error("Failed to fetch weather data", "error", err)
-		return backend.ErrDataResponse(backend.StatusInternal, fmt.Sprintf("Failed to fetch weather data: %v", err.Error()))
+		logger.Error("Failed to fetch weather data", "error", err)
+		return errorResponse(err, "Failed to fetch weather data")
 	}
 
+	city := weatherData[0].City
+	queryTraceFrom(ctx).setLocation(resolvedLocation{Name: city.Name, Lat: city.Coord.Lat, Lon: city.Coord.Lon})
+
 	// Convert the weather data to frames
 	frame, err := d.createDataFrames(weatherData, qm)
 	if err != nil {
-		d.logger.Error("Failed to create frames", "error", err)
+		logger.Error("Failed to create frames", "error", err)
 		return backend.ErrDataResponse(backend.StatusInternal, fmt.Sprintf("Failed to create frames: %v", err.Error()))
 	}
 
+	logger.Debug("Created data frame", "frameSize", frame.Rows())
+
+	frame.AppendNotices(notices...)
+	var shift time.Duration
+	if qm.LocalDays {
+		shift = time.Duration(city.Timezone) * time.Second
+	}
+	frame.AppendNotices(coverageNotices(frame, query.TimeRange, forecastStep, shift)...)
+
 	// Add the frame to the response
 	response.Frames = append(response.Frames, frame)
-	d.logger.Info("Successfully processed query", "framesCount", len(response.Frames))
+	logger.Debug("Successfully processed query", "framesCount", len(response.Frames))
 
 	return response
 }
@@ -192,10 +327,14 @@ func (d *Datasource) createDataFrames(weatherResponses []WeatherResponse, qm que
 	var times []time.Time
 	var values []float64
 	var descriptions []string
+	var conditions conditionColumns
+	var local localTimeColumns
+	var fallbacks fallbackCounter
+	city := weatherResponses[0].City
 
 	// Extract data from the weather response
 	for _, item := range weatherResponses[0].List {
-		timestamp := time.Unix(item.Dt, 0)
+		timestamp := localTime(item, city, qm.LocalDays)
 		times = append(times, timestamp)
 
 		// Extract values based on mainParameter and subParameter
@@ -221,7 +360,7 @@ func (d *Datasource) createDataFrames(weatherResponses []WeatherResponse, qm que
 			case "humidity":
 				value = item.Main.Humidity
 			default:
-				value = item.Main.Temp
+				return nil, fmt.Errorf("%w format %q", errUnknownMetric, qm.Format)
 			}
 		case "wind":
 			switch qm.Format {
@@ -232,25 +371,35 @@ func (d *Datasource) createDataFrames(weatherResponses []WeatherResponse, qm que
 			case "gust":
 				value = item.Wind.Gust
 			default:
-				value = item.Wind.Speed
+				return nil, fmt.Errorf("%w format %q", errUnknownMetric, qm.Format)
 			}
 		case "clouds":
 			value = item.Clouds.All
 		case "rain":
+			// Items without rain report no "rain" object at all
 			if item.Rain != nil {
 				value = item.Rain.ThreeH
 			}
 		default:
-			value = item.Main.Temp
+			return nil, fmt.Errorf("%w %q", errUnknownMetric, qm.Metric)
 		}
 
 		values = append(values, value)
+		fallbacks.add(item, qm)
 
 		if len(item.Weather) > 0 {
 			descriptions = append(descriptions, item.Weather[0].Description)
 		} else {
 			descriptions = append(descriptions, "")
 		}
+
+		if qm.Conditions {
+			conditions.append(item)
+		}
+
+		if qm.LocalTime {
+			local.append(item, city)
+		}
 	}
 
 	// Add fields to the frame
@@ -260,198 +409,95 @@ func (d *Datasource) createDataFrames(weatherResponses []WeatherResponse, qm que
 		data.NewField("description", nil, descriptions),
 	)
 
+	if qm.Conditions {
+		frame.Fields = append(frame.Fields, conditions.fields()...)
+	}
+
+	if qm.LocalTime {
+		frame.Fields = append(frame.Fields, local.fields()...)
+	}
+
 	// Add city name and selected parameter as labels
 	frame.Name = weatherResponses[0].City.Name
 	frame.Meta = &data.FrameMeta{
 		Custom: map[string]interface{}{
 			"city":      weatherResponses[0].City.Name,
 			"parameter": qm.Metric + "." + qm.Format,
+			"timezone":  formatUTCOffset(city.Timezone),
+			"localDays": qm.LocalDays,
 		},
 	}
-
-	d.logger.Info("Created data frame",
-		"frameSize", len(times),
-		"cityName", weatherResponses[0].City.Name,
-		"parameter", qm.Metric+"."+qm.Format)
+	frame.AppendNotices(fallbacks.notices()...)
 
 	return frame, nil
 }
 
-func (d *Datasource) GetHistoricalWeather(city string, apiKey string, qm queryModel) ([]WeatherResponse, error) {
-	// Validate API key
-	if apiKey == "" {
-		d.logger.Error("API key is missing")
-		return nil, fmt.Errorf("missing API key: please add a valid OpenWeather API key in the datasource configuration")
+// requestLogAttributes returns the datasource and user of a request for the log context
+func requestLogAttributes(pCtx backend.PluginContext) []interface{} {
+	var attrs []interface{}
+	if pCtx.DataSourceInstanceSettings != nil {
+		attrs = append(attrs, "dsUid", pCtx.DataSourceInstanceSettings.UID)
 	}
-
-	// Fix base URL if needed
-	baseURL := d.baseURL
-	if !strings.HasPrefix(baseURL, "http") {
-		baseURL = "https://api.openweathermap.org/data/2.5"
-	}
-
-	// Use proper format for OpenWeatherMap API URL
-	url := fmt.Sprintf("%s?q=%s&appid=%s&units=metric", baseURL, city, apiKey)
-
-	d.logger.Info("Fetching weather data",
-		"city", city,
-		"metric", qm.Metric,
-		"baseURL", baseURL)
-
-	// Create a new HTTP client with timeout
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+	if pCtx.User != nil {
+		attrs = append(attrs, "uname", pCtx.User.Name)
 	}
+	return attrs
+}
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		d.logger.Error("Error creating request", "error", err)
-		return nil, fmt.Errorf("error creating request: %w", err)
+// maxConcurrency returns how many of the queries of a request may run in parallel. A
+// MaxConcurrency of 0 runs all of them in parallel.
+func (d *Datasource) maxConcurrency(queries int) int {
+	switch {
+	case d.settings == nil || d.settings.MaxConcurrency < 0:
+		return 1
+	case d.settings.MaxConcurrency == 0:
+		return max(queries, 1)
+	default:
+		return d.settings.MaxConcurrency
 	}
+}
 
-	// Add additional request headers
-	req.Header.Add("Accept", "application/json")
-
-	d.logger.Info("Sending request to OpenWeather API", "url_without_key", strings.Replace(url, apiKey, "API_KEY_HIDDEN", 1))
-	resp, err := client.Do(req)
-	if err != nil {
-		d.logger.Error("Error making request", "error", err)
-		return nil, fmt.Errorf("error making request: %w", err)
+// weatherParams returns the query parameters for a city based request
+func (d *Datasource) weatherParams(city string, units string) url.Values {
+	params := url.Values{
+		"q":     {city},
+		"units": {units},
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		d.logger.Error("Error reading response", "error", err)
-		return nil, fmt.Errorf("error reading response: %w", err)
+	if d.settings.DefaultLanguage != "" {
+		params.Set("lang", d.settings.DefaultLanguage)
 	}
+	return params
+}
 
-	// Enhanced error handling
-	if resp.StatusCode != http.StatusOK {
-		errorMsg := string(body)
-		d.logger.Error("API returned error",
-			"status", resp.StatusCode,
-			"body", errorMsg)
-
-		// Check specific error codes
-		if resp.StatusCode == 401 {
-			return nil, fmt.Errorf("authentication failed: invalid API key (401). Please verify your API key is correct and active")
-		} else if resp.StatusCode == 404 {
-			return nil, fmt.Errorf("city not found: %s (404)", city)
-		} else if resp.StatusCode == 429 {
-			return nil, fmt.Errorf("API rate limit exceeded (429). Please check your subscription plan")
-		}
-
-		return nil, fmt.Errorf("API request failed with status code: %d - %s", resp.StatusCode, errorMsg)
-	}
+func (d *Datasource) GetHistoricalWeather(ctx context.Context, city string, qm queryModel) ([]WeatherResponse, error) {
+	logger := d.logger.FromContext(ctx)
+	logger.Debug("Fetching weather data",
+		"city", city,
+		"metric", qm.Metric,
+		"endpoint", EndpointForecast)
 
 	var weatherResponse WeatherResponse
-	err = json.Unmarshal(body, &weatherResponse)
+	err := d.fetch(ctx, EndpointForecast, d.weatherParams(city, qm.Units), &weatherResponse)
 	if err != nil {
-		d.logger.Error("Error unmarshalling response", "error", err, "body", string(body))
-		return nil, fmt.Errorf("error unmarshalling response: %w", err)
+		return nil, err
 	}
 
 	// Validate response
 	if weatherResponse.Cod != "200" {
-		d.logger.Error("API returned error", "code", weatherResponse.Cod, "message", weatherResponse.Message)
-		return nil, fmt.Errorf("API returned error code: %s", weatherResponse.Cod)
+		logger.Error("API returned error", "code", weatherResponse.Cod, "message", weatherResponse.Message)
+		return nil, newPayloadError(EndpointForecast, "API returned error code: "+weatherResponse.Cod, nil)
 	}
 
 	if len(weatherResponse.List) == 0 {
-		d.logger.Error("API returned no data")
-		return nil, fmt.Errorf("API returned no weather data")
+		logger.Error("API returned no data")
+		return nil, newPayloadError(EndpointForecast, "API returned no weather data", nil)
 	}
 
 	// Return the single weather response in an array
 	weatherData := []WeatherResponse{weatherResponse}
-	d.logger.Info("Weather data retrieved successfully",
+	logger.Debug("Weather data retrieved successfully",
 		"city", weatherResponse.City.Name,
 		"items", len(weatherResponse.List))
 
 	return weatherData, nil
 }
-
-func (d *Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
-	logger := d.logger.FromContext(ctx)
-
-	// Load configuration
-	config, err := models.LoadPluginSettings(*req.PluginContext.DataSourceInstanceSettings)
-	if err != nil {
-		logger.Error("Failed to load settings", "error", err)
-		return &backend.CheckHealthResult{
-			Status:  backend.HealthStatusError,
-			Message: "Unable to load settings: " + err.Error(),
-		}, nil
-	}
-
-	// Check if API key exists
-	if config.Secrets.ApiKey == "" {
-		logger.Error("API key is missing")
-		return &backend.CheckHealthResult{
-			Status:  backend.HealthStatusError,
-			Message: "API key is missing. Please configure a valid OpenWeather API key",
-		}, nil
-	}
-
-	// Test connection with a simple request
-	testCity := "London" // Using a well-known city for the test
-
-	// Fix base URL if needed
-	baseURL := d.baseURL
-	if !strings.HasPrefix(baseURL, "http") {
-		baseURL = "https://api.openweathermap.org/data/2.5/forecast"
-	}
-
-	url := fmt.Sprintf("%s?q=%s&appid=%s&units=metric", baseURL, testCity, config.Secrets.ApiKey)
-
-	// Create a client with short timeout for health check
-	client := &http.Client{
-		Timeout: 5 * time.Second,
-	}
-
-	logger.Info("Testing API connection", "url", strings.Replace(url, config.Secrets.ApiKey, "API_KEY_HIDDEN", 1))
-
-	httpReq, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		logger.Error("Failed to create request", "error", err)
-		return &backend.CheckHealthResult{
-			Status:  backend.HealthStatusError,
-			Message: "Failed to create test request: " + err.Error(),
-		}, nil
-	}
-
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		logger.Error("Failed to connect to API", "error", err)
-		return &backend.CheckHealthResult{
-			Status:  backend.HealthStatusError,
-			Message: "Failed to connect to OpenWeather API: " + err.Error(),
-		}, nil
-	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-
-	if resp.StatusCode != http.StatusOK {
-		logger.Error("API test failed", "status", resp.StatusCode, "body", string(body))
-
-		if resp.StatusCode == 401 {
-			return &backend.CheckHealthResult{
-				Status:  backend.HealthStatusError,
-				Message: "Authentication failed: Invalid API key. Please check your API key in the datasource configuration.",
-			}, nil
-		}
-
-		return &backend.CheckHealthResult{
-			Status:  backend.HealthStatusError,
-			Message: fmt.Sprintf("API returned error: %d - %s", resp.StatusCode, string(body)),
-		}, nil
-	}
-
-	logger.Info("Health check successful")
-	return &backend.CheckHealthResult{
-		Status:  backend.HealthStatusOk,
-		Message: "Successfully connected to OpenWeather API",
-	}, nil
-}
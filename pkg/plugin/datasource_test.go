@@ -2,17 +2,245 @@ package plugin
 
 import (
 	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/models"
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/plugin/instrumentation"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 )
 
+func newTestDatasource() *Datasource {
+	return newTestDatasourceWithSettings(func(*models.PluginSettings) {})
+}
+
+// newTestDatasourceWithSettings returns a datasource for the default settings changed by configure
+func newTestDatasourceWithSettings(configure func(*models.PluginSettings)) *Datasource {
+	settings := models.NewPluginSettings()
+	settings.Secrets = &models.SecretPluginSettings{}
+	settings.Migrate()
+	settings.ApplyDefaults()
+	configure(settings)
+
+	return newDatasource(settings, instrumentation.WrapLogger(log.New()), instrumentation.NewTracingHelper(nil),
+		instrumentation.NewMetrics("openweather_test").WithDatasource("test"))
+}
+
+// testResponses are canned upstream responses served by newTestServer, keyed by path
+var testResponses = map[string]string{
+	"/data/2.5/forecast":      `{"cod": "200", "list": [{"dt": 1700000000, "main": {"temp": 4.2}, "weather": [{"id": 500, "description": "light rain"}]}], "city": {"name": "Marburg", "timezone": 3600}}`,
+	"/data/2.5/weather":       `{"dt": 1700000000, "name": "Marburg", "main": {"temp": 5.5, "humidity": 80}, "wind": {"speed": 3.1}}`,
+	"/geo/1.0/direct":         `[{"name": "Marburg", "lat": 50.81, "lon": 8.77, "country": "DE"}]`,
+	"/data/2.5/air_pollution": `{"coord": {"lat": 50.81, "lon": 8.77}, "list": [{"dt": 1700000000, "main": {"aqi": 2}, "components": {"pm10": 12.5, "o3": 40.1}}]}`,
+	"/data/3.0/onecall":       `{"lat": 50.81, "lon": 8.77, "alerts": [{"sender_name": "DWD", "event": "Frost", "start": 1700000000, "end": 1700036000}]}`,
+	"/data/2.5/history/city":  `{"cod": "200", "list": [{"dt": 1699990000, "main": {"temp": 3.9}}]}`,
+}
+
+// testRequests records the requests a test server received
+type testRequests struct {
+	mu   sync.Mutex
+	urls []*url.URL
+	// closedConns counts the connections that were closed
+	closedConns atomic.Int32
+}
+
+// paths returns the requested paths in order
+func (r *testRequests) paths() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	paths := make([]string, 0, len(r.urls))
+	for _, u := range r.urls {
+		paths = append(paths, u.Path)
+	}
+	return paths
+}
+
+// keys returns the API keys of the requests in order
+func (r *testRequests) keys() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := make([]string, 0, len(r.urls))
+	for _, u := range r.urls {
+		keys = append(keys, u.Query().Get("appid"))
+	}
+	return keys
+}
+
+// count returns the number of requests of path
+func (r *testRequests) count(path string) int {
+	return len(slices.DeleteFunc(r.paths(), func(p string) bool { return p != path }))
+}
+
+// testServerOption changes how newTestServer answers. An option returns true once it
+// answered the request, false to leave it to the other options and testResponses.
+type testServerOption func(w http.ResponseWriter, r *http.Request) bool
+
+// statusOption answers a request with the status returned by status, unless that is 0
+func statusOption(status func(r *http.Request) int) testServerOption {
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		code := status(r)
+		if code == 0 {
+			return false
+		}
+		w.WriteHeader(code)
+		return true
+	}
+}
+
+// withStatus answers the paths in statuses with their status code
+func withStatus(statuses map[string]int) testServerOption {
+	return statusOption(func(r *http.Request) int { return statuses[r.URL.Path] })
+}
+
+// withKeyStatus answers the requests of the API keys in statuses with their status code
+func withKeyStatus(statuses map[string]int) testServerOption {
+	return statusOption(func(r *http.Request) int { return statuses[r.URL.Query().Get("appid")] })
+}
+
+// withPlans rejects the paths listed for a key in denied like OpenWeather rejects endpoints
+// outside the plan of a key. Keys that are not listed are rejected everywhere.
+func withPlans(denied map[string][]string) testServerOption {
+	return statusOption(func(r *http.Request) int {
+		paths, ok := denied[r.URL.Query().Get("appid")]
+		if !ok || slices.Contains(paths, r.URL.Path) {
+			return http.StatusUnauthorized
+		}
+		return 0
+	})
+}
+
+// withFailure answers every request with 503 while failing is set
+func withFailure(failing *atomic.Bool) testServerOption {
+	return statusOption(func(*http.Request) int {
+		if failing.Load() {
+			return http.StatusServiceUnavailable
+		}
+		return 0
+	})
+}
+
+// withFirstStatus answers the first n requests with status
+func withFirstStatus(status int, n int32) testServerOption {
+	var requests atomic.Int32
+	return statusOption(func(*http.Request) int {
+		if requests.Add(1) <= n {
+			return status
+		}
+		return 0
+	})
+}
+
+// withDelay holds every request back for delay. Requests that are cancelled meanwhile are
+// not answered.
+func withDelay(delay time.Duration) testServerOption {
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		select {
+		case <-time.After(delay):
+			return false
+		case <-r.Context().Done():
+			return true
+		}
+	}
+}
+
+// withHandler answers every request with handler, for responses the other options do not
+// cover like error bodies or dropped connections
+func withHandler(handler http.HandlerFunc) testServerOption {
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		handler(w, r)
+		return true
+	}
+}
+
+// newTestServer serves testResponses and records the requests and closed connections.
+// Options answer requests differently instead.
+func newTestServer(t *testing.T, options ...testServerOption) (*httptest.Server, *testRequests) {
+	t.Helper()
+	requests := &testRequests{}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.mu.Lock()
+		requests.urls = append(requests.urls, r.URL)
+		requests.mu.Unlock()
+
+		for _, option := range options {
+			if option(w, r) {
+				return
+			}
+		}
+		body, ok := testResponses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			requests.closedConns.Add(1)
+		}
+	}
+	server.Start()
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+// newTestDatasourceWithServer returns a datasource that sends upstream calls to server,
+// with the settings changed by configure
+func newTestDatasourceWithServer(server *httptest.Server, configure ...func(*models.PluginSettings)) *Datasource {
+	return newTestDatasourceWithSettings(func(settings *models.PluginSettings) {
+		settings.APIRoot = server.URL
+		settings.Secrets.ApiKey = "test-key"
+		for _, c := range configure {
+			c(settings)
+		}
+	})
+}
+
+func TestZeroDisablesCacheAndRateLimit(t *testing.T) {
+	server, requests := newTestServer(t)
+	settings, err := models.LoadPluginSettings(backend.DataSourceInstanceSettings{
+		JSONData:                []byte(fmt.Sprintf(`{"apiRoot": %q, "cacheTTLSeconds": 0, "rateLimitPerMinute": 0}`, server.URL)),
+		DecryptedSecureJSONData: map[string]string{"apiKey": "test-key"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.CacheTTLSeconds != 0 || settings.RateLimitPerMinute != 0 {
+		t.Fatalf("expected the explicit zeros to be kept, got %+v", settings)
+	}
+	ds := newDatasource(settings, instrumentation.WrapLogger(log.New()), instrumentation.NewTracingHelper(nil),
+		instrumentation.NewMetrics("openweather_test").WithDatasource("test"))
+
+	// More calls than the default limit of 60 per minute, all of them reach OpenWeather
+	calls := models.DefaultRateLimitPerMinute + 5
+	for i := 0; i < calls; i++ {
+		if res := queryCurrent(t, ds); res.Error != nil {
+			t.Fatalf("query %d failed: %v", i, res.Error)
+		}
+	}
+	if n := len(requests.paths()); n != calls {
+		t.Errorf("expected %d upstream calls without cache and rate limit, got %d", calls, n)
+	}
+}
+
 func TestQueryData(t *testing.T) {
-	ds := Datasource{}
+	ds := newTestDatasource()
 
 	resp, err := ds.QueryData(
 		context.Background(),
 		&backend.QueryDataRequest{
+			PluginContext: backend.PluginContext{
+				DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{},
+			},
 			Queries: []backend.DataQuery{
 				{RefID: "A"},
 			},
@@ -26,3 +254,129 @@ func TestQueryData(t *testing.T) {
 		t.Fatal("QueryData must return a response")
 	}
 }
+
+func TestCreateDataFramesConditions(t *testing.T) {
+	ds := newTestDatasource()
+	weather := []WeatherResponse{{
+		City: CityInfo{Name: "Marburg"},
+		List: []ForecastItem{
+			{Dt: 1700000000, Weather: []Weather{{ID: 211, Main: "Thunderstorm", Description: "thunderstorm", Icon: "11d"}}},
+			{Dt: 1700010800, Weather: []Weather{{ID: 800, Main: "Clear", Description: "clear sky", Icon: "01n"}}},
+			{Dt: 1700021600},
+		},
+	}}
+
+	frame, err := ds.createDataFrames(weather, queryModel{Metric: "main", Format: "temp", Conditions: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	field, _ := frame.FieldByName("condition")
+	if field == nil {
+		t.Fatal("condition field is missing")
+	}
+	for i, want := range []string{conditionThunderstorm, conditionClear, conditionUnknown} {
+		if got := field.At(i).(string); got != want {
+			t.Errorf("condition[%d] = %q, want %q", i, got, want)
+		}
+	}
+	if len(field.Config.Mappings) == 0 {
+		t.Error("condition field has no value mappings")
+	}
+
+	thunder, _ := frame.FieldByName("thunderstorm")
+	if !thunder.At(0).(bool) || thunder.At(1).(bool) {
+		t.Error("thunderstorm flag is not set correctly")
+	}
+
+	icon, _ := frame.FieldByName("icon")
+	if got := icon.At(0).(string); got != "https://openweathermap.org/img/wn/11d@2x.png" {
+		t.Errorf("unexpected icon URL %q", got)
+	}
+	if got := icon.At(2).(string); got != "" {
+		t.Errorf("expected empty icon URL for missing weather, got %q", got)
+	}
+
+	frame, err = ds.createDataFrames(weather, queryModel{Metric: "main", Format: "temp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, _ := frame.FieldByName("condition"); f != nil {
+		t.Error("condition fields must only be added when requested")
+	}
+}
+
+func TestConditionGroup(t *testing.T) {
+	for id, want := range map[int]string{
+		200: conditionThunderstorm,
+		301: conditionDrizzle,
+		502: conditionRain,
+		601: conditionSnow,
+		741: conditionAtmosphere,
+		800: conditionClear,
+		804: conditionClouds,
+		0:   conditionUnknown,
+	} {
+		if got := conditionGroup(id); got != want {
+			t.Errorf("conditionGroup(%d) = %q, want %q", id, got, want)
+		}
+	}
+}
+
+func TestCreateDataFramesLocalTime(t *testing.T) {
+	ds := newTestDatasource()
+	city := CityInfo{Name: "Tokyo", Timezone: 9 * 3600, Sunrise: 1700000000, Sunset: 1700036000}
+	weather := []WeatherResponse{{
+		City: city,
+		List: []ForecastItem{
+			{Dt: 1700000000, Sys: Sys{Pod: "n"}},
+			{Dt: 1700010800},
+			{Dt: 1700046800},
+		},
+	}}
+
+	frame, err := ds.createDataFrames(weather, queryModel{Metric: "main", Format: "temp", LocalTime: true, LocalDays: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := frame.Fields[0].At(0).(time.Time); !got.Equal(time.Unix(1700000000+9*3600, 0)) {
+		t.Errorf("time field is not shifted by the city offset: %v", got)
+	}
+
+	local, _ := frame.FieldByName("local_time")
+	if got := local.At(0).(string); got != "2023-11-15 07:13" {
+		t.Errorf("unexpected local time %q", got)
+	}
+
+	offset, _ := frame.FieldByName("utc_offset")
+	if got := offset.At(0).(string); got != "+09:00" {
+		t.Errorf("unexpected UTC offset %q", got)
+	}
+
+	day, _ := frame.FieldByName("is_day")
+	for i, want := range []bool{false, true, false} {
+		if got := day.At(i).(bool); got != want {
+			t.Errorf("is_day[%d] = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestFormatUTCOffset(t *testing.T) {
+	for offset, want := range map[int]string{0: "+00:00", 19800: "+05:30", -12600: "-03:30"} {
+		if got := formatUTCOffset(offset); got != want {
+			t.Errorf("formatUTCOffset(%d) = %q, want %q", offset, got, want)
+		}
+	}
+}
+
+func TestNewDatasourceCanBeCreatedAgain(t *testing.T) {
+	settings := backend.DataSourceInstanceSettings{UID: "openweather", JSONData: []byte(`{}`)}
+	for i := 0; i < 2; i++ {
+		instance, err := NewDatasource(context.Background(), settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		instance.(*Datasource).Dispose()
+	}
+}
@@ -0,0 +1,94 @@
+package plugin
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/models"
+)
+
+// Endpoint identifies an OpenWeather API endpoint. Every upstream call resolves its URL
+// through the registry below, so the datasource only needs to know the API roots.
+type Endpoint string
+
+const (
+	EndpointForecast     Endpoint = "forecast"
+	EndpointWeather      Endpoint = "weather"
+	EndpointOneCall      Endpoint = "onecall"
+	EndpointGeo          Endpoint = "geo"
+	EndpointAirPollution Endpoint = "air_pollution"
+	EndpointHistory      Endpoint = "history"
+)
+
+// endpointPaths maps each endpoint to its path below its API root
+var endpointPaths = map[Endpoint]string{
+	EndpointForecast:     "/data/2.5/forecast",
+	EndpointWeather:      "/data/2.5/weather",
+	EndpointOneCall:      "/data/3.0/onecall",
+	EndpointGeo:          "/geo/1.0/direct",
+	EndpointAirPollution: "/data/2.5/air_pollution",
+	EndpointHistory:      "/data/2.5/history/city",
+}
+
+// historyEndpoints are served from the history API root instead of the API root
+var historyEndpoints = map[Endpoint]bool{
+	EndpointHistory: true,
+}
+
+// planEndpoints are only available with some OpenWeather plans. A 401 of these endpoints
+// may mean the product is not part of the plan rather than an invalid key.
+var planEndpoints = map[Endpoint]bool{
+	EndpointOneCall: true,
+	EndpointHistory: true,
+}
+
+// endpointFallbacks are endpoints that a query is routed to when its endpoint is not
+// included in the plan of the API key. A fallback must return the same data in the same
+// payload: the weather alerts of One Call are not served by any endpoint of the free
+// plan, so alerts queries fail with ErrNotInPlan instead of reporting no alerts.
+var endpointFallbacks = map[Endpoint]Endpoint{}
+
+// Endpoints returns all known endpoints in a stable order
+func Endpoints() []Endpoint {
+	return []Endpoint{
+		EndpointForecast,
+		EndpointWeather,
+		EndpointOneCall,
+		EndpointGeo,
+		EndpointAirPollution,
+		EndpointHistory,
+	}
+}
+
+// Path returns the path of the endpoint below the API root
+func (e Endpoint) Path() (string, error) {
+	path, ok := endpointPaths[e]
+	if !ok {
+		return "", fmt.Errorf("unknown endpoint: %q", string(e))
+	}
+	return path, nil
+}
+
+// root returns the API root that serves the endpoint
+func (e Endpoint) root(settings *models.PluginSettings) string {
+	if historyEndpoints[e] {
+		return settings.HistoryRoot()
+	}
+	return settings.APIRoot
+}
+
+// endpointURL builds the full request URL for an endpoint below its API root
+func endpointURL(settings *models.PluginSettings, e Endpoint, params url.Values) (string, error) {
+	path, err := e.Path()
+	if err != nil {
+		return "", err
+	}
+
+	root := e.root(settings)
+	u, err := url.Parse(root + path)
+	if err != nil {
+		return "", fmt.Errorf("invalid API root %q: %w", root, err)
+	}
+	u.RawQuery = params.Encode()
+	return u.String(), nil
+}
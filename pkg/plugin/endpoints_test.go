@@ -0,0 +1,54 @@
+package plugin
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/models"
+)
+
+func TestEndpointURL(t *testing.T) {
+	settings := &models.PluginSettings{APIRoot: models.DefaultAPIRoot}
+	got, err := endpointURL(settings, EndpointForecast, url.Values{"q": {"Frankfurt am Main"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://api.openweathermap.org/data/2.5/forecast?q=Frankfurt+am+Main"; got != want {
+		t.Errorf("endpointURL = %q, want %q", got, want)
+	}
+
+	for _, e := range Endpoints() {
+		if _, err := e.Path(); err != nil {
+			t.Errorf("endpoint %q has no path: %v", e, err)
+		}
+	}
+
+	if _, err := endpointURL(settings, Endpoint("unknown"), nil); err == nil {
+		t.Error("expected an error for an unknown endpoint")
+	}
+}
+
+func TestHistoryEndpointURL(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		settings models.PluginSettings
+		want     string
+	}{
+		{"default", models.PluginSettings{APIRoot: models.DefaultAPIRoot}, "https://history.openweathermap.org/data/2.5/history/city"},
+		{"custom API root", models.PluginSettings{APIRoot: "http://proxy:8080"}, "http://proxy:8080/data/2.5/history/city"},
+		{"history API root", models.PluginSettings{APIRoot: models.DefaultAPIRoot, HistoryAPIRoot: "http://history-proxy"}, "http://history-proxy/data/2.5/history/city"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := endpointURL(&tc.settings, EndpointHistory, nil)
+			if err != nil || got != tc.want {
+				t.Errorf("endpointURL = %q %v, want %q", got, err, tc.want)
+			}
+		})
+	}
+
+	// Every other endpoint stays below the API root
+	settings := &models.PluginSettings{APIRoot: models.DefaultAPIRoot}
+	if got, _ := endpointURL(settings, EndpointWeather, nil); got != "https://api.openweathermap.org/data/2.5/weather" {
+		t.Errorf("unexpected weather URL %q", got)
+	}
+}
@@ -0,0 +1,222 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/plugin/instrumentation"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// Kinds of upstream failures. UpstreamError matches them with errors.Is.
+var (
+	ErrUnauthorized        = errors.New("unauthorized")
+	ErrNotInPlan           = errors.New("not included in plan")
+	ErrNotFound            = errors.New("not found")
+	ErrRateLimited         = errors.New("rate limited")
+	ErrBadRequest          = errors.New("bad request")
+	ErrUpstreamUnavailable = errors.New("upstream unavailable")
+	ErrMalformedPayload    = errors.New("malformed payload")
+)
+
+// UpstreamError is a failed call to the OpenWeather API. These failures are caused
+// downstream of the plugin, so they are reported with backend.ErrorSourceDownstream.
+type UpstreamError struct {
+	// Kind is one of the Err* kinds above
+	Kind error
+	// Endpoint is the OpenWeather endpoint that was called
+	Endpoint Endpoint
+	// StatusCode is the HTTP status returned by OpenWeather, 0 if no response was received
+	StatusCode int
+	// Message is a human readable description including a hint how to resolve the problem
+	Message string
+	// Err is the underlying error, if any
+	Err error
+}
+
+func (e *UpstreamError) Error() string {
+	msg := e.Message
+	if e.StatusCode != 0 {
+		msg = fmt.Sprintf("%s (%d)", msg, e.StatusCode)
+	}
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %v", msg, e.Err)
+	}
+	return msg
+}
+
+// Is matches the kind of the error
+func (e *UpstreamError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *UpstreamError) Unwrap() error {
+	return e.Err
+}
+
+// Status maps the kind of the error to the status of the data response
+func (e *UpstreamError) Status() backend.Status {
+	switch e.Kind {
+	case ErrUnauthorized:
+		return backend.StatusUnauthorized
+	case ErrNotInPlan:
+		return backend.StatusForbidden
+	case ErrNotFound:
+		return backend.StatusNotFound
+	case ErrRateLimited:
+		return backend.StatusTooManyRequests
+	case ErrBadRequest:
+		return backend.StatusBadRequest
+	case ErrUpstreamUnavailable:
+		if isTimeout(e.Err) || e.StatusCode == http.StatusGatewayTimeout {
+			return backend.StatusTimeout
+		}
+		return backend.StatusBadGateway
+	default:
+		return backend.StatusBadGateway
+	}
+}
+
+// ErrorType maps the kind of the error to the error_type label of the metrics
+func (e *UpstreamError) ErrorType() string {
+	switch e.Kind {
+	case ErrUnauthorized:
+		return instrumentation.ErrorTypeUnauthorized
+	case ErrNotInPlan:
+		return instrumentation.ErrorTypeNotInPlan
+	case ErrNotFound:
+		return instrumentation.ErrorTypeNotFound
+	case ErrRateLimited:
+		return instrumentation.ErrorTypeRateLimited
+	case ErrBadRequest:
+		return instrumentation.ErrorTypeBadRequest
+	case ErrUpstreamUnavailable:
+		if e.Status() == backend.StatusTimeout {
+			return instrumentation.ErrorTypeTimeout
+		}
+		return instrumentation.ErrorTypeUpstreamUnavailable
+	default:
+		return instrumentation.ErrorTypeMalformedPayload
+	}
+}
+
+// newStatusError classifies a non-200 response of OpenWeather
+func newStatusError(endpoint Endpoint, statusCode int, body string) *UpstreamError {
+	e := &UpstreamError{Endpoint: endpoint, StatusCode: statusCode}
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		e.Kind = ErrUnauthorized
+		e.Message = "authentication failed: invalid API key. Please verify your API key is correct and active"
+	case statusCode == http.StatusNotFound:
+		e.Kind = ErrNotFound
+		e.Message = "location not found"
+	case statusCode == http.StatusTooManyRequests:
+		e.Kind = ErrRateLimited
+		e.Message = "API rate limit exceeded. Please check your subscription plan"
+	case statusCode >= 500:
+		e.Kind = ErrUpstreamUnavailable
+		e.Message = "OpenWeather API is unavailable"
+	default:
+		// Other client errors like 400 reject the parameters of the request, the payload
+		// is only malformed when a 200 response cannot be decoded
+		e.Kind = ErrBadRequest
+		e.Message = "OpenWeather rejected the request: " + body
+	}
+	return e
+}
+
+// newNotInPlanError reports an endpoint that rejected a valid API key, because the
+// product is not part of the plan of the key
+func newNotInPlanError(endpoint Endpoint, statusCode int) *UpstreamError {
+	return &UpstreamError{
+		Kind:       ErrNotInPlan,
+		Endpoint:   endpoint,
+		StatusCode: statusCode,
+		Message: fmt.Sprintf("the %s endpoint is not included in your OpenWeather plan. The API key is valid, "+
+			"subscribe to the product or upgrade the plan to use this query", endpoint),
+	}
+}
+
+// newTransportError classifies a request that did not receive a response
+func newTransportError(endpoint Endpoint, err error) *UpstreamError {
+	return &UpstreamError{
+		Kind:     ErrUpstreamUnavailable,
+		Endpoint: endpoint,
+		Message:  "error making request",
+		Err:      err,
+	}
+}
+
+// newPayloadError classifies a response that could not be decoded or holds no data
+func newPayloadError(endpoint Endpoint, message string, err error) *UpstreamError {
+	return &UpstreamError{
+		Kind:     ErrMalformedPayload,
+		Endpoint: endpoint,
+		Message:  message,
+		Err:      err,
+	}
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// redactError removes secrets from the message of err, keeping upstream errors typed
+func (d *Datasource) redactError(err error) error {
+	var upstreamErr *UpstreamError
+	if !errors.As(err, &upstreamErr) || upstreamErr != err {
+		return d.redactor.Error(err)
+	}
+	redacted := *upstreamErr
+	redacted.Message = d.redactor.String(redacted.Message)
+	redacted.Err = d.redactor.Error(redacted.Err)
+	return &redacted
+}
+
+// redactResponse removes secrets from the error and notices of a data response
+func (d *Datasource) redactResponse(res *backend.DataResponse) {
+	res.Error = d.redactor.Error(res.Error)
+	for _, frame := range res.Frames {
+		if frame.Meta == nil {
+			continue
+		}
+		frame.Meta.ExecutedQueryString = d.redactor.String(frame.Meta.ExecutedQueryString)
+		for i := range frame.Meta.Notices {
+			frame.Meta.Notices[i].Text = d.redactor.String(frame.Meta.Notices[i].Text)
+		}
+	}
+}
+
+// errorResponse turns an error into a data response. Upstream errors keep their status
+// and are marked as downstream errors, everything else is an internal plugin error.
+// The response error wraps err, so its error type can still be determined.
+func errorResponse(err error, message string) backend.DataResponse {
+	res := backend.DataResponse{
+		Error:       fmt.Errorf("%s: %w", message, err),
+		Status:      backend.StatusInternal,
+		ErrorSource: backend.ErrorSourcePlugin,
+	}
+
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		res.Status = upstreamErr.Status()
+		res.ErrorSource = backend.ErrorSourceDownstream
+	}
+	return res
+}
+
+// responseErrorType returns the error_type label for a failed data response
+func responseErrorType(res backend.DataResponse) string {
+	var typer instrumentation.ErrorTyper
+	if !errors.As(res.Error, &typer) && res.Status == backend.StatusBadRequest {
+		return instrumentation.ErrorTypeBadRequest
+	}
+	return instrumentation.ErrorType(res.Error)
+}
@@ -0,0 +1,168 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/models"
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/plugin/instrumentation"
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/plugin/instrumentation/logtest"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestUpstreamErrorClassification(t *testing.T) {
+	for _, tc := range []struct {
+		statusCode int
+		body       string
+		kind       error
+		status     backend.Status
+	}{
+		{http.StatusUnauthorized, `{"cod": 401, "message": "Invalid API key"}`, ErrUnauthorized, backend.StatusUnauthorized},
+		{http.StatusNotFound, `{"cod": "404", "message": "city not found"}`, ErrNotFound, backend.StatusNotFound},
+		{http.StatusTooManyRequests, `{"cod": 429}`, ErrRateLimited, backend.StatusTooManyRequests},
+		{http.StatusServiceUnavailable, `unavailable`, ErrUpstreamUnavailable, backend.StatusBadGateway},
+		{http.StatusGatewayTimeout, `timeout`, ErrUpstreamUnavailable, backend.StatusTimeout},
+		{http.StatusBadRequest, `{"cod": "400", "message": "wrong latitude"}`, ErrBadRequest, backend.StatusBadRequest},
+		{http.StatusUnprocessableEntity, `{"cod": "422"}`, ErrBadRequest, backend.StatusBadRequest},
+		{http.StatusOK, `not json`, ErrMalformedPayload, backend.StatusBadGateway},
+	} {
+		server, _ := newTestServer(t, withHandler(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tc.statusCode)
+			_, _ = w.Write([]byte(tc.body))
+		}))
+		ds := newTestDatasourceWithServer(server)
+
+		_, err := ds.GetHistoricalWeather(context.Background(), "Marburg", queryModel{Units: "metric"})
+		server.Close()
+
+		if !errors.Is(err, tc.kind) {
+			t.Errorf("status %d: expected %v, got %v", tc.statusCode, tc.kind, err)
+			continue
+		}
+
+		var upstreamErr *UpstreamError
+		if !errors.As(err, &upstreamErr) {
+			t.Fatalf("status %d: expected an UpstreamError", tc.statusCode)
+		}
+		if tc.statusCode != http.StatusOK && upstreamErr.StatusCode != tc.statusCode {
+			t.Errorf("status %d: HTTP status was not preserved, got %d", tc.statusCode, upstreamErr.StatusCode)
+		}
+
+		res := errorResponse(err, "Failed to fetch weather data")
+		if res.Status != tc.status {
+			t.Errorf("status %d: expected response status %v, got %v", tc.statusCode, tc.status, res.Status)
+		}
+		if res.ErrorSource != backend.ErrorSourceDownstream {
+			t.Errorf("status %d: expected a downstream error, got %q", tc.statusCode, res.ErrorSource)
+		}
+	}
+}
+
+func TestErrorResponsePluginError(t *testing.T) {
+	res := errorResponse(errors.New("boom"), "Failed to create frames")
+	if res.Status != backend.StatusInternal || res.ErrorSource != backend.ErrorSourcePlugin {
+		t.Errorf("expected an internal plugin error, got %v %q", res.Status, res.ErrorSource)
+	}
+}
+
+func TestResponseErrorType(t *testing.T) {
+	for _, tc := range []struct {
+		res  backend.DataResponse
+		want string
+	}{
+		{errorResponse(newStatusError(EndpointWeather, http.StatusNotFound, ""), "Failed"), instrumentation.ErrorTypeNotFound},
+		{errorResponse(newStatusError(EndpointWeather, http.StatusGatewayTimeout, ""), "Failed"), instrumentation.ErrorTypeTimeout},
+		{errorResponse(newStatusError(EndpointWeather, http.StatusMethodNotAllowed, ""), "Failed"), instrumentation.ErrorTypeBadRequest},
+		{errorResponse(newPayloadError(EndpointWeather, "error unmarshalling response", nil), "Failed"), instrumentation.ErrorTypeMalformedPayload},
+		{errorResponse(errors.New("boom"), "Failed"), instrumentation.ErrorTypeInternal},
+		{backend.ErrDataResponse(backend.StatusBadRequest, "invalid query"), instrumentation.ErrorTypeBadRequest},
+	} {
+		if got := responseErrorType(tc.res); got != tc.want {
+			t.Errorf("%v: expected %q, got %q", tc.res.Error, tc.want, got)
+		}
+	}
+}
+
+func TestAPIKeyNeverLeaks(t *testing.T) {
+	const apiKey = "leaky-secret-key"
+
+	for name, handler := range map[string]http.HandlerFunc{
+		// OpenWeather echoing the request in an error body
+		"error body": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = fmt.Fprintf(w, `{"cod": 401, "message": "Invalid API key %s for %s"}`, apiKey, r.URL)
+		},
+		// A transport error, whose url.Error contains the full request URL
+		"transport error": func(w http.ResponseWriter, r *http.Request) {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			_ = conn.Close()
+		},
+		"malformed payload": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("appid=" + apiKey))
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			server, _ := newTestServer(t, withHandler(handler))
+
+			logger := logtest.New()
+			spans := tracetest.NewSpanRecorder()
+			settings := models.NewPluginSettings()
+			settings.Secrets = &models.SecretPluginSettings{ApiKey: apiKey}
+			settings.Migrate()
+			settings.ApplyDefaults()
+			settings.APIRoot = server.URL
+			ds := newDatasource(settings, instrumentation.WrapLogger(logger),
+				instrumentation.NewTracingHelper(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)).Tracer("test")),
+				instrumentation.NewMetrics("openweather_test").WithDatasource("test"))
+			defer ds.Dispose()
+
+			resp, err := ds.QueryData(context.Background(), queryTypeRequest(
+				backend.DataQuery{RefID: "A", QueryType: QueryTypeCurrent, JSON: []byte(`{"city": "Marburg"}`)},
+				backend.DataQuery{RefID: "B", JSON: []byte(`{"city": "Marburg", "metric": "main"}`)},
+			))
+			if err != nil {
+				t.Fatal(err)
+			}
+			health, _ := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{
+				PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+					JSONData:                []byte(fmt.Sprintf(`{"apiRoot": %q}`, server.URL)),
+					DecryptedSecureJSONData: map[string]string{"apiKey": apiKey},
+				}},
+			})
+
+			var leaks []string
+			check := func(where string, s string) {
+				if strings.Contains(s, apiKey) {
+					leaks = append(leaks, where+": "+s)
+				}
+			}
+			for refID, res := range resp.Responses {
+				if res.Error == nil {
+					t.Errorf("%s: expected the query to fail", refID)
+					continue
+				}
+				check("error "+refID, res.Error.Error())
+				for _, frame := range res.Frames {
+					check("frame "+refID, fmt.Sprint(frame.Meta))
+				}
+			}
+			check("health", health.Message)
+			check("health details", string(health.JSONDetails))
+			for _, entry := range logger.Entries() {
+				check("log", entry)
+			}
+			for _, span := range spans.Ended() {
+				check("span "+span.Name(), fmt.Sprint(span.Attributes(), span.Events(), span.Status()))
+			}
+			for _, leak := range leaks {
+				t.Errorf("API key leaked in %s", leak)
+			}
+		})
+	}
+}
@@ -0,0 +1,405 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// healthCheckTimeout bounds all probes of a health check together
+const healthCheckTimeout = 5 * time.Second
+
+// Outcomes of a health probe
+const (
+	probeOK      = "ok"
+	probeFailed  = "error"
+	probeSkipped = "skipped"
+)
+
+// healthProbe checks whether the API key can access one API product
+type healthProbe struct {
+	product  string
+	endpoint Endpoint
+	params   func(location resolvedLocation) url.Values
+}
+
+// healthProbes are run in parallel once the test location is resolved. The geocoding API
+// is probed first, since it resolves the test location.
+var healthProbes = []healthProbe{
+	{"forecast", EndpointForecast, func(l resolvedLocation) url.Values {
+		params := l.params()
+		params.Set("cnt", "1")
+		return params
+	}},
+	{"current", EndpointWeather, resolvedLocation.params},
+	{"oneCall", EndpointOneCall, func(l resolvedLocation) url.Values {
+		params := l.params()
+		params.Set("exclude", "minutely,hourly,daily")
+		return params
+	}},
+	{"history", EndpointHistory, func(l resolvedLocation) url.Values {
+		end := time.Now().Truncate(time.Hour)
+		params := l.params()
+		params.Set("type", "hour")
+		params.Set("start", strconv.FormatInt(end.Add(-time.Hour).Unix(), 10))
+		params.Set("end", strconv.FormatInt(end.Unix(), 10))
+		return params
+	}},
+	{"airPollution", EndpointAirPollution, resolvedLocation.params},
+}
+
+// requiredProducts must be accessible for the datasource to be healthy, the default
+// forecast and current weather queries depend on them
+var requiredProducts = []string{"forecast", "current"}
+
+// healthDetails are returned as JSONDetails of the health check
+type healthDetails struct {
+	APIRoot   string              `json:"apiRoot"`
+	KeySource models.APIKeySource `json:"keySource"`
+	Keys      int                 `json:"keys"`
+	// Key is the ID of the API key the products were probed with. With several keys the
+	// products and probes only hold for that key, the others may have different plans.
+	Key      string          `json:"key"`
+	Location *healthLocation `json:"location,omitempty"`
+	Proxy    proxyStatus     `json:"proxy"`
+	Products map[string]bool `json:"products"`
+	Probes   []probeResult   `json:"probes"`
+}
+
+type healthLocation struct {
+	Query string  `json:"query"`
+	Name  string  `json:"name,omitempty"`
+	Lat   float64 `json:"lat"`
+	Lon   float64 `json:"lon"`
+}
+
+// proxyStatus tells whether requests to the API root go through an HTTP proxy
+type proxyStatus struct {
+	Enabled bool   `json:"enabled"`
+	URL     string `json:"url,omitempty"`
+}
+
+// probeResult is the outcome of one health probe
+type probeResult struct {
+	Product    string   `json:"product"`
+	Endpoint   Endpoint `json:"endpoint"`
+	Status     string   `json:"status"`
+	HTTPStatus int      `json:"httpStatus,omitempty"`
+	LatencyMs  int64    `json:"latencyMs"`
+	Error      string   `json:"error,omitempty"`
+	Hint       string   `json:"hint,omitempty"`
+	// Cached is set when the entitlement was known from the plan cache and not probed
+	Cached bool `json:"cached,omitempty"`
+}
+
+// CheckHealth probes the OpenWeather API products the datasource uses. Secrets are
+// removed from the result message, the details only hold redacted errors.
+func (d *Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
+	res, err := d.checkHealth(ctx, req)
+	if res != nil {
+		res.Message = d.redactor.String(res.Message)
+	}
+	return res, d.redactor.Error(err)
+}
+
+func (d *Datasource) checkHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
+	logger := d.logger.FromContext(ctx)
+
+	ctx, done, err := d.start(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	// Load configuration
+	config, err := models.LoadPluginSettings(*req.PluginContext.DataSourceInstanceSettings)
+	if err != nil {
+		logger.Error("Failed to load settings", "error", err)
+		return &backend.CheckHealthResult{
+			Status:  backend.HealthStatusError,
+			Message: "Unable to load settings: " + err.Error(),
+		}, nil
+	}
+
+	// Check if API key exists
+	keys := config.Secrets.Keys()
+	if len(keys) == 0 {
+		logger.Error("API key is missing")
+		return &backend.CheckHealthResult{
+			Status:  backend.HealthStatusError,
+			Message: "API key is missing. Please configure a valid OpenWeather API key, an environment variable or a file that holds it",
+		}, nil
+	}
+	d.redactor.Add(keys...)
+	keySource := describeAPIKeySource(config)
+
+	details := healthDetails{
+		APIRoot:   config.APIRoot,
+		KeySource: config.Secrets.ApiKeySource,
+		Keys:      len(keys),
+		Key:       keyID(keys[0]),
+		Proxy:     proxyFor(config.APIRoot),
+		Products:  map[string]bool{},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	logger.Info("Testing API connection", "apiRoot", config.APIRoot, "location", config.HealthCheckTarget())
+	probe := func(product string, endpoint Endpoint, params url.Values) probeOutcome {
+		return d.runProbe(ctx, config, keySource, product, endpoint, params)
+	}
+
+	// Resolve the test location, probing the geocoding API for city names
+	target := config.HealthCheckTarget()
+	var location *resolvedLocation
+	if lat, lon, ok := models.ParseCoordinates(target); ok {
+		location = &resolvedLocation{Lat: lat, Lon: lon}
+		details.Probes = append(details.Probes, probeResult{
+			Product: "geocoding", Endpoint: EndpointGeo, Status: probeSkipped,
+			Hint: "The health check location is given as coordinates, geocoding is not needed",
+		})
+	} else {
+		result := probe("geocoding", EndpointGeo, url.Values{"q": {target}, "limit": {"1"}})
+		if result.Status == probeOK {
+			location = result.location
+			if location == nil {
+				result.Status = probeFailed
+				result.Error = "location not found: " + target
+				result.Hint = fmt.Sprintf("The health check location %q was not found, configure a different health check location", target)
+			}
+		}
+		details.Probes = append(details.Probes, result.probeResult)
+	}
+
+	results := make([]probeResult, len(healthProbes))
+	var wg sync.WaitGroup
+	for i, p := range healthProbes {
+		if location == nil {
+			results[i] = probeResult{
+				Product: p.product, Endpoint: p.endpoint, Status: probeSkipped,
+				Hint: "Skipped because the health check location could not be resolved",
+			}
+			continue
+		}
+		if result, ok := d.knownEntitlement(config, keySource, details.Key, p); ok {
+			results[i] = result
+			continue
+		}
+		wg.Add(1)
+		go func(i int, p healthProbe) {
+			defer wg.Done()
+			results[i] = probe(p.product, p.endpoint, p.params(*location)).probeResult
+		}(i, p)
+	}
+	wg.Wait()
+	details.Probes = append(details.Probes, results...)
+
+	d.recordEntitlements(details.Key, details.Probes)
+
+	if location != nil {
+		details.Location = &healthLocation{Query: target, Name: location.Name, Lat: location.Lat, Lon: location.Lon}
+	}
+	for _, result := range details.Probes {
+		if result.Status != probeSkipped {
+			details.Products[result.Product] = result.Status == probeOK
+		}
+	}
+
+	jsonDetails, err := json.Marshal(details)
+	if err != nil {
+		return nil, err
+	}
+	res := &backend.CheckHealthResult{JSONDetails: jsonDetails}
+
+	if failed := failedRequiredProbe(details); failed != nil {
+		logger.Error("API test failed", "product", failed.Product, "status", failed.HTTPStatus, "error", failed.Error)
+		res.Status = backend.HealthStatusError
+		res.Message = fmt.Sprintf("OpenWeather %s API check failed: %s. %s", failed.Product, failed.Error, failed.Hint)
+		return res, nil
+	}
+
+	logger.Info("Health check successful")
+	res.Status = backend.HealthStatusOk
+	res.Message = fmt.Sprintf("Successfully connected to OpenWeather API, using the API key from %s", keySource)
+	if unavailable := unavailableProducts(details); len(unavailable) > 0 {
+		res.Message += fmt.Sprintf(". Not available with API key %s: %s", details.Key, strings.Join(unavailable, ", "))
+	}
+	if details.Keys > 1 {
+		res.Message += fmt.Sprintf(". The products were checked with API key %s only, the other %d keys may have different plans", details.Key, details.Keys-1)
+	}
+	return res, nil
+}
+
+// probeOutcome is a probe result with the location a geocoding probe found
+type probeOutcome struct {
+	probeResult
+	location *resolvedLocation
+}
+
+// runProbe calls endpoint with the first API key of config and reports the outcome. The
+// call counts against the rate limit of the key, but it bypasses the circuit breakers, the
+// key pool and the upstream metrics, so a health check does not change how queries are
+// served.
+func (d *Datasource) runProbe(ctx context.Context, config *models.PluginSettings, keySource string, product string, endpoint Endpoint, params url.Values) probeOutcome {
+	outcome := probeOutcome{probeResult: probeResult{Product: product, Endpoint: endpoint, Status: probeOK}}
+
+	key := config.Secrets.Keys()[0]
+	params.Set("appid", key)
+	requestURL, err := endpointURL(config, endpoint, params)
+	if err != nil {
+		outcome.Status = probeFailed
+		outcome.Error = d.redactor.String(err.Error())
+		outcome.Hint = "Check the API root of the datasource"
+		return outcome
+	}
+
+	start := time.Now()
+	var body []byte
+	if err = d.limiter.allow(ctx, endpoint, &pooledKey{key: key, id: keyID(key)}); err == nil {
+		body, err = d.send(ctx, endpoint, requestURL, func(int, int) {})
+	}
+	outcome.LatencyMs = time.Since(start).Milliseconds()
+	if err == nil && endpoint == EndpointGeo {
+		var locations []GeoLocation
+		if err = json.Unmarshal(body, &locations); err != nil {
+			err = newPayloadError(endpoint, "error unmarshalling response", err)
+		} else if len(locations) > 0 {
+			outcome.location = &resolvedLocation{Name: locations[0].Name, Lat: locations[0].Lat, Lon: locations[0].Lon}
+		}
+	}
+	if err == nil {
+		outcome.HTTPStatus = http.StatusOK
+		return outcome
+	}
+
+	outcome.Status = probeFailed
+	outcome.Error = d.redactor.String(err.Error())
+	outcome.Hint = remediationHint(config, keySource, endpoint, err)
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		outcome.HTTPStatus = upstreamErr.StatusCode
+	}
+	return outcome
+}
+
+// knownEntitlement answers the probe of an optional product from the plan cache, so
+// repeated health checks do not call OpenWeather for entitlements that are already known.
+// Required products are always probed, they tell whether the datasource works at all.
+func (d *Datasource) knownEntitlement(config *models.PluginSettings, keySource string, id string, p healthProbe) (probeResult, bool) {
+	if slices.Contains(requiredProducts, p.product) {
+		return probeResult{}, false
+	}
+	allowed, known := d.plans.lookup(id, p.endpoint)
+	if !known {
+		return probeResult{}, false
+	}
+	result := probeResult{Product: p.product, Endpoint: p.endpoint, Status: probeOK, Cached: true}
+	if !allowed {
+		err := newNotInPlanError(p.endpoint, http.StatusUnauthorized)
+		result.Status = probeFailed
+		result.HTTPStatus = err.StatusCode
+		result.Error = err.Error()
+		result.Hint = remediationHint(config, keySource, p.endpoint, err)
+	}
+	return result, true
+}
+
+// recordEntitlements seeds the plan cache with the products a health check probed. Plan
+// endpoints that rejected a key which other endpoints accepted are not part of its plan.
+// Probes answered from the plan cache are not recorded again, so their entitlements still
+// expire.
+func (d *Datasource) recordEntitlements(id string, probes []probeResult) {
+	valid := false
+	for _, probe := range probes {
+		if probe.Status == probeOK && !probe.Cached {
+			valid = true
+			d.plans.record(id, probe.Endpoint, true)
+		}
+	}
+	if !valid {
+		return
+	}
+	for i, probe := range probes {
+		if probe.Status == probeFailed && !probe.Cached && planEndpoints[probe.Endpoint] && probe.HTTPStatus == http.StatusUnauthorized {
+			d.plans.record(id, probe.Endpoint, false)
+			probes[i].Error = newNotInPlanError(probe.Endpoint, probe.HTTPStatus).Error()
+		}
+	}
+}
+
+// remediationHint tells the user how to fix a failed probe
+func remediationHint(config *models.PluginSettings, keySource string, endpoint Endpoint, err error) string {
+	var upstreamErr *UpstreamError
+	errors.As(err, &upstreamErr)
+
+	switch {
+	case (errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrNotInPlan)) && endpoint == EndpointOneCall:
+		return "One Call API 3.0 needs a separate \"One Call by Call\" subscription, subscribe to it to use alert queries"
+	case (errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrNotInPlan)) && endpoint == EndpointHistory:
+		return "The history API is only included in paid plans, upgrade the plan to use history queries"
+	case errors.Is(err, ErrUnauthorized):
+		return fmt.Sprintf("Please check the API key from %s. New keys can take up to 2 hours to be activated", keySource)
+	case errors.Is(err, ErrRateLimited):
+		return "The API key is rate limited. Wait for the quota to reset, lower the rate limit of the datasource or add more API keys"
+	case errors.Is(err, ErrNotFound):
+		return fmt.Sprintf("The endpoint was not found, check that the API root %s points at the OpenWeather API", endpoint.root(config))
+	case errors.Is(err, ErrBadRequest):
+		return "OpenWeather rejected the parameters of the request, check the health check location"
+	case errors.Is(err, ErrUpstreamUnavailable) && (upstreamErr == nil || upstreamErr.StatusCode == 0):
+		return fmt.Sprintf("Could not reach %s, check the network, firewall and proxy settings of the Grafana server", endpoint.root(config))
+	case errors.Is(err, ErrUpstreamUnavailable):
+		return "OpenWeather is unavailable, try again later"
+	case errors.Is(err, ErrMalformedPayload):
+		return "The response does not come from the OpenWeather API, check the API root and any proxy in between"
+	default:
+		return ""
+	}
+}
+
+// failedRequiredProbe returns the first failed probe of a product the datasource cannot
+// work without. Geocoding is required unless the location is given as coordinates.
+func failedRequiredProbe(details healthDetails) *probeResult {
+	for i, result := range details.Probes {
+		if result.Status == probeFailed && (result.Endpoint == EndpointGeo || slices.Contains(requiredProducts, result.Product)) {
+			return &details.Probes[i]
+		}
+	}
+	return nil
+}
+
+// unavailableProducts lists the optional products the key cannot access
+func unavailableProducts(details healthDetails) []string {
+	var products []string
+	for _, result := range details.Probes {
+		if result.Status == probeFailed {
+			products = append(products, result.Product)
+		}
+	}
+	return products
+}
+
+// proxyFor reports the HTTP proxy that the environment configures for apiRoot
+func proxyFor(apiRoot string) proxyStatus {
+	u, err := url.Parse(apiRoot)
+	if err != nil {
+		return proxyStatus{}
+	}
+	proxyURL, err := httpproxy.FromEnvironment().ProxyFunc()(u)
+	if err != nil || proxyURL == nil {
+		return proxyStatus{}
+	}
+	return proxyStatus{Enabled: true, URL: proxyURL.Redacted()}
+}
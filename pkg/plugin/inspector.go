@@ -0,0 +1,159 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// upstreamCall is a single call to the OpenWeather API, retries included
+type upstreamCall struct {
+	endpoint Endpoint
+	// url is the request URL with the API key redacted
+	url      string
+	duration time.Duration
+	bytes    int
+	retries  int
+	cacheHit bool
+	// fetchedAt is when the data was fetched from OpenWeather, zero if the call failed
+	fetchedAt time.Time
+}
+
+// queryTrace collects what a query did upstream, so it can be shown in the query inspector
+type queryTrace struct {
+	mu       sync.Mutex
+	calls    []upstreamCall
+	location *resolvedLocation
+	notices  []data.Notice
+}
+
+type queryTraceKey struct{}
+
+// withQueryTrace returns a context that records upstream calls into a new trace
+func withQueryTrace(ctx context.Context) (context.Context, *queryTrace) {
+	trace := &queryTrace{}
+	return context.WithValue(ctx, queryTraceKey{}, trace), trace
+}
+
+// queryTraceFrom returns the trace of ctx. Without a trace nothing is recorded.
+func queryTraceFrom(ctx context.Context) *queryTrace {
+	trace, _ := ctx.Value(queryTraceKey{}).(*queryTrace)
+	return trace
+}
+
+func (t *queryTrace) addCall(call upstreamCall) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.calls = append(t.calls, call)
+}
+
+// setLocation records the location the query resolved to
+func (t *queryTrace) setLocation(location resolvedLocation) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.location = &location
+}
+
+// addNotice records a notice about how the query was answered, like a fallback endpoint
+func (t *queryTrace) addNotice(notice data.Notice) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.notices = append(t.notices, notice)
+}
+
+// apply adds the executed requests, their statistics, the resolved location, the notices,
+// the time the oldest data was fetched and the query model version to every frame of the
+// response. The fetch time is only added as a field when the query options ask for it.
+func (t *queryTrace) apply(res *backend.DataResponse, options queryOptions) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.calls) == 0 && t.location == nil {
+		return
+	}
+
+	urls := make([]string, 0, len(t.calls))
+	var (
+		latency              time.Duration
+		bytes, retries, hits int
+		fetchedAt            time.Time
+	)
+	for _, call := range t.calls {
+		if !call.fetchedAt.IsZero() && (fetchedAt.IsZero() || call.fetchedAt.Before(fetchedAt)) {
+			fetchedAt = call.fetchedAt
+		}
+		urls = append(urls, call.url)
+		latency += call.duration
+		bytes += call.bytes
+		retries += call.retries
+		if call.cacheHit {
+			hits++
+		}
+	}
+	stats := []data.QueryStat{
+		{FieldConfig: data.FieldConfig{DisplayName: "Upstream latency", Unit: "ms"}, Value: float64(latency.Microseconds()) / 1000},
+		{FieldConfig: data.FieldConfig{DisplayName: "Payload size", Unit: "decbytes"}, Value: float64(bytes)},
+		{FieldConfig: data.FieldConfig{DisplayName: "Upstream requests"}, Value: float64(len(t.calls))},
+		{FieldConfig: data.FieldConfig{DisplayName: "Retries"}, Value: float64(retries)},
+		{FieldConfig: data.FieldConfig{DisplayName: "Cache hits"}, Value: float64(hits)},
+		{FieldConfig: data.FieldConfig{DisplayName: "Cache misses"}, Value: float64(len(t.calls) - hits)},
+	}
+
+	for _, frame := range res.Frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		frame.Meta.ExecutedQueryString = strings.Join(urls, "\n")
+		frame.Meta.Stats = append(frame.Meta.Stats, stats...)
+		frame.AppendNotices(t.notices...)
+
+		custom, ok := frame.Meta.Custom.(map[string]interface{})
+		if !ok {
+			custom = map[string]interface{}{}
+			frame.Meta.Custom = custom
+		}
+		custom["schemaVersion"] = queryModelVersion
+		if !fetchedAt.IsZero() {
+			custom["fetched_at"] = fetchedAt.UTC().Format(time.RFC3339)
+			if options.FetchedAtField {
+				addFetchedAtField(frame, fetchedAt)
+			}
+		}
+		if t.location != nil {
+			custom["location"] = map[string]interface{}{
+				"name": t.location.Name,
+				"lat":  t.location.Lat,
+				"lon":  t.location.Lon,
+			}
+		}
+	}
+}
+
+// addFetchedAtField adds the time the data was fetched from OpenWeather to every row of
+// frame, so panels and transformations can use the age of cached data
+func addFetchedAtField(frame *data.Frame, fetchedAt time.Time) {
+	if len(frame.Fields) == 0 {
+		return
+	}
+	if field, _ := frame.FieldByName("fetched_at"); field != nil {
+		return
+	}
+	values := make([]time.Time, frame.Rows())
+	for i := range values {
+		values[i] = fetchedAt.UTC()
+	}
+	frame.Fields = append(frame.Fields, data.NewField("fetched_at", nil, values))
+}
@@ -0,0 +1,154 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func queryStat(frame *data.Frame, name string) (float64, bool) {
+	for _, stat := range frame.Meta.Stats {
+		if stat.DisplayName == name {
+			return stat.Value, true
+		}
+	}
+	return 0, false
+}
+
+func TestQueryInspectorMetadata(t *testing.T) {
+	server, _ := newTestServer(t)
+	ds := newTestDatasourceWithServer(server)
+
+	resp, err := ds.QueryData(context.Background(), queryTypeRequest(
+		backend.DataQuery{RefID: "A", QueryType: QueryTypeAirQuality, JSON: []byte(`{"city": "Marburg"}`)},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := resp.Responses["A"]
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	meta := res.Frames[0].Meta
+
+	executed := meta.ExecutedQueryString
+	if strings.Contains(executed, "test-key") {
+		t.Errorf("executed query string leaks the API key: %s", executed)
+	}
+	if lines := strings.Split(executed, "\n"); len(lines) != 2 ||
+		!strings.Contains(lines[0], "/geo/1.0/direct") || !strings.Contains(lines[1], "/data/2.5/air_pollution") {
+		t.Errorf("expected the geocoding and air pollution requests, got %q", executed)
+	}
+
+	if n, ok := queryStat(res.Frames[0], "Upstream requests"); !ok || n != 2 {
+		t.Errorf("expected 2 upstream requests, got %v", n)
+	}
+	if n, ok := queryStat(res.Frames[0], "Payload size"); !ok || n == 0 {
+		t.Errorf("expected a payload size, got %v", n)
+	}
+	if n, ok := queryStat(res.Frames[0], "Cache misses"); !ok || n != 2 {
+		t.Errorf("expected 2 cache misses, got %v", n)
+	}
+
+	custom := meta.Custom.(map[string]interface{})
+	if custom["schemaVersion"] != queryModelVersion {
+		t.Errorf("expected schema version %d, got %v", queryModelVersion, custom["schemaVersion"])
+	}
+	location, ok := custom["location"].(map[string]interface{})
+	if !ok || location["name"] != "Marburg" || location["lat"] != 50.81 || location["lon"] != 8.77 {
+		t.Errorf("unexpected resolved location %v", custom["location"])
+	}
+	if _, ok := custom["fetched_at"].(string); !ok {
+		t.Errorf("expected the fetch time in the metadata, got %v", custom["fetched_at"])
+	}
+	if field, _ := res.Frames[0].FieldByName("fetched_at"); field != nil {
+		t.Error("expected no fetched_at field unless the query asks for it")
+	}
+}
+
+func TestQueryInspectorCountsRetries(t *testing.T) {
+	server, _ := newTestServer(t, withFirstStatus(http.StatusServiceUnavailable, 1))
+	ds := newTestDatasourceWithSettings(func(s *models.PluginSettings) {
+		s.APIRoot = server.URL
+		s.Secrets.ApiKey = "test-key"
+		s.MaxRetries = 1
+	})
+
+	resp, err := ds.QueryData(context.Background(), queryTypeRequest(
+		backend.DataQuery{RefID: "A", QueryType: QueryTypeCurrent, JSON: []byte(`{"city": "Marburg"}`)},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := resp.Responses["A"]
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if n, _ := queryStat(res.Frames[0], "Retries"); n != 1 {
+		t.Errorf("expected 1 retry, got %v", n)
+	}
+	if n, _ := queryStat(res.Frames[0], "Upstream requests"); n != 1 {
+		t.Errorf("expected the retried call to count once, got %v", n)
+	}
+}
+
+// testMetric returns the metric of the test datasource with the given name and labels,
+// nil if it was not recorded
+func testMetric(t *testing.T, name string, labels map[string]string) *dto.Metric {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "grafana_plugin_openweather_test_"+name {
+			continue
+		}
+	metrics:
+		for _, metric := range family.GetMetric() {
+			values := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				values[label.GetName()] = label.GetValue()
+			}
+			if values["datasource_uid"] != "test" {
+				continue
+			}
+			for k, v := range labels {
+				if values[k] != v {
+					continue metrics
+				}
+			}
+			return metric
+		}
+	}
+	return nil
+}
+
+// upstreamCalls returns the number of upstream calls recorded for the test datasource
+func upstreamCalls(t *testing.T, endpoint Endpoint, statusClass string) float64 {
+	t.Helper()
+	return testMetric(t, "upstream_requests_total", map[string]string{"endpoint": string(endpoint), "status_class": statusClass}).GetCounter().GetValue()
+}
+
+func TestUpstreamMetrics(t *testing.T) {
+	server, _ := newTestServer(t)
+	ds := newTestDatasourceWithServer(server)
+	before := upstreamCalls(t, EndpointWeather, "2xx")
+
+	if _, err := ds.QueryData(context.Background(), queryTypeRequest(
+		backend.DataQuery{RefID: "A", QueryType: QueryTypeCurrent, JSON: []byte(`{"city": "Marburg"}`)},
+	)); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := upstreamCalls(t, EndpointWeather, "2xx") - before; n != 1 {
+		t.Errorf("expected 1 recorded weather call, got %v", n)
+	}
+}
@@ -1,44 +1,128 @@
 package instrumentation
+
 import (
 	"context"
+	"sync"
+
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// DefaultSampleRate is how many occurrences of a sampled message share one log line
+const DefaultSampleRate = 10
+
+// Logger logs through the plugin SDK. Loggers returned by FromContext add the contextual
+// attributes of the request, like trace ID, RefID, datasource UID and user.
 type Logger struct {
-	logger log.Logger
+	logger   log.Logger
+	sampler  *sampler
+	redactor *Redactor
 }
 
 func NewLogger() *Logger {
+	return WrapLogger(backend.Logger)
+}
+
+// WrapLogger returns a Logger that writes to logger
+func WrapLogger(logger log.Logger) *Logger {
 	return &Logger{
-		logger: backend.Logger,
+		logger:  logger,
+		sampler: newSampler(DefaultSampleRate),
 	}
 }
 
 func (l *Logger) With(args ...interface{}) *Logger {
 	return &Logger{
-		logger: l.logger.With(args...),
+		logger:   l.logger.With(l.redactor.Args(args)...),
+		sampler:  l.sampler,
+		redactor: l.redactor,
 	}
 }
 
+// WithRedactor returns a logger that removes the secrets of redactor from every message.
+// Without a redactor only appid parameters are removed.
+func (l *Logger) WithRedactor(redactor *Redactor) *Logger {
+	return &Logger{
+		logger:   l.logger,
+		sampler:  l.sampler,
+		redactor: redactor,
+	}
+}
+
+// FromContext returns a logger with the attributes added by WithLogAttributes and the
+// trace ID of the current span
 func (l *Logger) FromContext(ctx context.Context) *Logger {
+	if traceID := trace.SpanContextFromContext(ctx).TraceID(); traceID.IsValid() {
+		ctx = WithLogAttributes(ctx, "traceId", traceID.String())
+	}
 	return &Logger{
-		logger: l.logger.FromContext(ctx),
+		logger:   l.logger.With(l.redactor.Args(log.ContextualAttributesFromContext(ctx))...),
+		sampler:  l.sampler,
+		redactor: l.redactor,
+	}
+}
+
+// WithLogAttributes returns a context whose loggers add args to every message. Keys that
+// are already set, for example by the SDK middleware, are not added again.
+func WithLogAttributes(ctx context.Context, args ...interface{}) context.Context {
+	existing := map[interface{}]bool{}
+	attrs := log.ContextualAttributesFromContext(ctx)
+	for i := 0; i < len(attrs); i += 2 {
+		existing[attrs[i]] = true
 	}
+
+	var added []interface{}
+	for i := 0; i+1 < len(args); i += 2 {
+		if !existing[args[i]] {
+			added = append(added, args[i], args[i+1])
+		}
+	}
+	if len(added) == 0 {
+		return ctx
+	}
+	return log.WithContextualAttributes(ctx, added)
 }
 
 func (l *Logger) Debug(msg string, args ...interface{}) {
-	l.logger.Debug(msg, args...)
+	l.logger.Debug(l.redactor.String(msg), l.redactor.Args(args)...)
 }
 
 func (l *Logger) Info(msg string, args ...interface{}) {
-	l.logger.Info(msg, args...)
+	l.logger.Info(l.redactor.String(msg), l.redactor.Args(args)...)
 }
 
 func (l *Logger) Warn(msg string, args ...interface{}) {
-	l.logger.Warn(msg, args...)
+	l.logger.Warn(l.redactor.String(msg), l.redactor.Args(args)...)
 }
 
 func (l *Logger) Error(msg string, args ...interface{}) {
-	l.logger.Error(msg, args...)
-}
\ No newline at end of file
+	l.logger.Error(l.redactor.String(msg), l.redactor.Args(args)...)
+}
+
+// SampledDebug logs high-volume debug messages. Only the first of every DefaultSampleRate
+// occurrences of msg is logged, with the number of occurrences it stands for.
+func (l *Logger) SampledDebug(msg string, args ...interface{}) {
+	if l.sampler.allow(msg) {
+		l.Debug(msg, append(args, "sampled", l.sampler.rate)...)
+	}
+}
+
+// sampler counts the occurrences of messages to log only every rate-th of them
+type sampler struct {
+	rate   int
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newSampler(rate int) *sampler {
+	return &sampler{rate: rate, counts: map[string]int{}}
+}
+
+func (s *sampler) allow(msg string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.counts[msg]
+	s.counts[msg] = (n + 1) % s.rate
+	return n == 0
+}
@@ -0,0 +1,56 @@
+package instrumentation
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/plugin/instrumentation/logtest"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestLoggerFromContext(t *testing.T) {
+	recorder := logtest.New()
+	logger := WrapLogger(recorder)
+
+	traceID := trace.TraceID{1}
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID,
+		SpanID:  trace.SpanID{2},
+	}))
+	ctx = log.WithContextualAttributes(ctx, []interface{}{"dsUid", "from-sdk"})
+	ctx = WithLogAttributes(ctx, "dsUid", "duplicate", "refId", "A")
+
+	logger.FromContext(ctx).Debug("Processing query", "city", "Marburg")
+
+	want := fmt.Sprint("debug Processing query ", []interface{}{"dsUid", "from-sdk", "refId", "A", "traceId", traceID.String(), "city", "Marburg"})
+	if entries := recorder.Entries(); len(entries) != 1 || entries[0] != want {
+		t.Errorf("expected %q, got %q", want, entries)
+	}
+}
+
+func TestLoggerSampledDebug(t *testing.T) {
+	recorder := logtest.New()
+	logger := WrapLogger(recorder)
+
+	for i := 0; i < 2*DefaultSampleRate+1; i++ {
+		logger.SampledDebug("Sending request")
+		logger.With("endpoint", "weather").SampledDebug("Other message")
+	}
+
+	var sending, other int
+	for _, entry := range recorder.Entries() {
+		switch entry {
+		case fmt.Sprint("debug Sending request ", []interface{}{"sampled", DefaultSampleRate}):
+			sending++
+		case fmt.Sprint("debug Other message ", []interface{}{"endpoint", "weather", "sampled", DefaultSampleRate}):
+			other++
+		default:
+			t.Errorf("unexpected entry %q", entry)
+		}
+	}
+	if sending != 3 || other != 3 {
+		t.Errorf("expected 3 entries per message, got %d and %d", sending, other)
+	}
+}
@@ -0,0 +1,57 @@
+// Package logtest provides a log.Logger for tests that keeps every message it was asked to
+// log, so tests can check what the plugin logs.
+package logtest
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Logger is a log.Logger that records every message with its level and arguments. Loggers
+// derived with With and FromContext record into the same entries.
+type Logger struct {
+	args    []interface{}
+	entries *entries
+}
+
+type entries struct {
+	mu     sync.Mutex
+	values []string
+}
+
+// New returns a logger without entries
+func New() *Logger {
+	return &Logger{entries: &entries{}}
+}
+
+// Entries returns the recorded entries formatted as "level message [arguments]"
+func (l *Logger) Entries() []string {
+	l.entries.mu.Lock()
+	defer l.entries.mu.Unlock()
+	return slices.Clone(l.entries.values)
+}
+
+func (l *Logger) log(level string, msg string, args ...interface{}) {
+	entry := fmt.Sprint(level, " ", msg, " ", append(slices.Clone(l.args), args...))
+	l.entries.mu.Lock()
+	defer l.entries.mu.Unlock()
+	l.entries.values = append(l.entries.values, entry)
+}
+
+func (l *Logger) Debug(msg string, args ...interface{}) { l.log("debug", msg, args...) }
+func (l *Logger) Info(msg string, args ...interface{})  { l.log("info", msg, args...) }
+func (l *Logger) Warn(msg string, args ...interface{})  { l.log("warn", msg, args...) }
+func (l *Logger) Error(msg string, args ...interface{}) { l.log("error", msg, args...) }
+func (l *Logger) Level() log.Level                      { return log.Debug }
+
+func (l *Logger) With(args ...interface{}) log.Logger {
+	return &Logger{args: append(slices.Clone(l.args), args...), entries: l.entries}
+}
+
+func (l *Logger) FromContext(ctx context.Context) log.Logger {
+	return l.With(log.ContextualAttributesFromContext(ctx)...)
+}
@@ -1,20 +1,138 @@
 package instrumentation
 
 import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
 )
 
-type Metrics struct {
+// Error types used as the error_type label. The set is fixed to keep the cardinality
+// of the metrics bounded.
+const (
+	ErrorTypeUnauthorized        = "unauthorized"
+	ErrorTypeNotInPlan           = "not_in_plan"
+	ErrorTypeNotFound            = "not_found"
+	ErrorTypeRateLimited         = "rate_limited"
+	ErrorTypeUpstreamUnavailable = "upstream_unavailable"
+	ErrorTypeMalformedPayload    = "malformed_payload"
+	ErrorTypeBadRequest          = "bad_request"
+	ErrorTypeTimeout             = "timeout"
+	ErrorTypeCanceled            = "canceled"
+	ErrorTypeInternal            = "internal"
+)
+
+var errorTypes = map[string]bool{
+	ErrorTypeUnauthorized:        true,
+	ErrorTypeNotInPlan:           true,
+	ErrorTypeNotFound:            true,
+	ErrorTypeRateLimited:         true,
+	ErrorTypeUpstreamUnavailable: true,
+	ErrorTypeMalformedPayload:    true,
+	ErrorTypeBadRequest:          true,
+	ErrorTypeTimeout:             true,
+	ErrorTypeCanceled:            true,
+	ErrorTypeInternal:            true,
+}
+
+// ErrorTyper is implemented by errors that know their error type
+type ErrorTyper interface {
+	ErrorType() string
+}
+
+// ErrorType returns the error type of err. Errors that do not implement ErrorTyper, or
+// report a type outside the fixed set, are internal errors.
+func ErrorType(err error) string {
+	var typer ErrorTyper
+	switch {
+	case errors.As(err, &typer):
+		if t := typer.ErrorType(); errorTypes[t] {
+			return t
+		}
+		return ErrorTypeInternal
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorTypeTimeout
+	case errors.Is(err, context.Canceled):
+		return ErrorTypeCanceled
+	default:
+		return ErrorTypeInternal
+	}
+}
+
+// collectors are the registered metrics of a plugin, shared by all its datasource instances
+type collectors struct {
 	requestDuration *prometheus.HistogramVec
 	requestsTotal   *prometheus.CounterVec
 	errorsTotal     *prometheus.CounterVec
-	requestsActive  prometheus.Gauge
+	requestsActive  *prometheus.GaugeVec
+
+	upstreamDuration     *prometheus.HistogramVec
+	upstreamRequests     *prometheus.CounterVec
+	upstreamResponseSize *prometheus.HistogramVec
+	upstreamInFlight     *prometheus.GaugeVec
+
+	apiKeyRequests  *prometheus.CounterVec
+	apiKeyAvailable *prometheus.GaugeVec
+
+	circuitState *prometheus.GaugeVec
+
+	rateLimited         *prometheus.CounterVec
+	rateLimiterDegraded *prometheus.GaugeVec
 }
 
+var (
+	registryMu sync.Mutex
+	registered = map[string]*collectors{}
+)
+
+// all returns every metric vector of the collectors
+func (c *collectors) all() []*prometheus.MetricVec {
+	return []*prometheus.MetricVec{
+		c.requestDuration.MetricVec,
+		c.requestsTotal.MetricVec,
+		c.errorsTotal.MetricVec,
+		c.requestsActive.MetricVec,
+		c.upstreamDuration.MetricVec,
+		c.upstreamRequests.MetricVec,
+		c.upstreamResponseSize.MetricVec,
+		c.upstreamInFlight.MetricVec,
+		c.apiKeyRequests.MetricVec,
+		c.apiKeyAvailable.MetricVec,
+		c.circuitState.MetricVec,
+		c.rateLimited.MetricVec,
+		c.rateLimiterDegraded.MetricVec,
+	}
+}
+
+// Metrics records the metrics of one datasource instance
+type Metrics struct {
+	*collectors
+	datasourceUID string
+}
+
+var (
+	instancesMu sync.Mutex
+	// instances counts the datasource instances per collectors and datasource UID. The
+	// instance replacing one with changed settings is created before the old one is
+	// disposed, so the series are only deleted when the last instance is released.
+	instances = map[*collectors]map[string]int{}
+)
+
+// NewMetrics returns the metrics of a plugin. The collectors are registered once per
+// process, so instances can be created again when the datasource settings change.
 func NewMetrics(pluginID string) *Metrics {
-	m := &Metrics{
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if c, ok := registered[pluginID]; ok {
+		return &Metrics{collectors: c}
+	}
+
+	c := &collectors{
 		requestDuration: prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
 				Namespace: "grafana_plugin",
@@ -23,7 +141,7 @@ func NewMetrics(pluginID string) *Metrics {
 				Help:      "Request duration in seconds.",
 				Buckets:   prometheus.DefBuckets,
 			},
-			[]string{"operation", "status"},
+			[]string{"datasource_uid", "operation", "status"},
 		),
 		requestsTotal: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
@@ -32,7 +150,7 @@ func NewMetrics(pluginID string) *Metrics {
 				Name:      "requests_total",
 				Help:      "Total number of requests.",
 			},
-			[]string{"operation"},
+			[]string{"datasource_uid", "operation"},
 		),
 		errorsTotal: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
@@ -41,39 +159,255 @@ func NewMetrics(pluginID string) *Metrics {
 				Name:      "errors_total",
 				Help:      "Total number of errors.",
 			},
-			[]string{"operation", "error_type"},
+			[]string{"datasource_uid", "operation", "error_type"},
 		),
-		requestsActive: prometheus.NewGauge(
+		requestsActive: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Namespace: "grafana_plugin",
 				Subsystem: pluginID,
 				Name:      "requests_active",
 				Help:      "Current number of active requests.",
 			},
+			[]string{"datasource_uid"},
+		),
+		upstreamDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: "grafana_plugin",
+				Subsystem: pluginID,
+				Name:      "upstream_request_duration_seconds",
+				Help:      "Duration of upstream API calls in seconds.",
+				Buckets:   prometheus.DefBuckets,
+			},
+			[]string{"datasource_uid", "endpoint", "status_class"},
+		),
+		upstreamRequests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "grafana_plugin",
+				Subsystem: pluginID,
+				Name:      "upstream_requests_total",
+				Help:      "Total number of upstream API calls.",
+			},
+			[]string{"datasource_uid", "endpoint", "status_class"},
+		),
+		upstreamResponseSize: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: "grafana_plugin",
+				Subsystem: pluginID,
+				Name:      "upstream_response_size_bytes",
+				Help:      "Size of upstream API responses in bytes.",
+				Buckets:   prometheus.ExponentialBuckets(256, 4, 8),
+			},
+			[]string{"datasource_uid", "endpoint"},
+		),
+		upstreamInFlight: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "grafana_plugin",
+				Subsystem: pluginID,
+				Name:      "upstream_requests_in_flight",
+				Help:      "Current number of upstream API calls in flight.",
+			},
+			[]string{"datasource_uid", "endpoint"},
+		),
+		apiKeyRequests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "grafana_plugin",
+				Subsystem: pluginID,
+				Name:      "api_key_requests_total",
+				Help:      "Total number of upstream API calls per API key.",
+			},
+			[]string{"datasource_uid", "key_id", "status_class"},
+		),
+		apiKeyAvailable: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "grafana_plugin",
+				Subsystem: pluginID,
+				Name:      "api_key_available",
+				Help:      "Whether an API key is in rotation (1) or cooling down after being rejected or rate limited (0).",
+			},
+			[]string{"datasource_uid", "key_id"},
+		),
+		circuitState: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "grafana_plugin",
+				Subsystem: pluginID,
+				Name:      "circuit_breaker_state",
+				Help:      "State of the circuit breaker of an upstream endpoint: 0 closed, 1 half-open, 2 open.",
+			},
+			[]string{"datasource_uid", "endpoint"},
+		),
+		rateLimited: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "grafana_plugin",
+				Subsystem: pluginID,
+				Name:      "rate_limited_requests_total",
+				Help:      "Total number of upstream API calls held back by the rate limit of an API key.",
+			},
+			[]string{"datasource_uid", "key_id", "limit"},
+		),
+		rateLimiterDegraded: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "grafana_plugin",
+				Subsystem: pluginID,
+				Name:      "rate_limiter_degraded",
+				Help:      "Whether the shared rate limit is unreachable and calls are limited per process (1) or not (0).",
+			},
+			[]string{"datasource_uid"},
 		),
 	}
 
 	prometheus.MustRegister(
-		m.requestDuration,
-		m.requestsTotal,
-		m.errorsTotal,
-		m.requestsActive,
+		c.requestDuration,
+		c.requestsTotal,
+		c.errorsTotal,
+		c.requestsActive,
+		c.upstreamDuration,
+		c.upstreamRequests,
+		c.upstreamResponseSize,
+		c.upstreamInFlight,
+		c.apiKeyRequests,
+		c.apiKeyAvailable,
+		c.circuitState,
+		c.rateLimited,
+		c.rateLimiterDegraded,
 	)
+	registered[pluginID] = c
+
+	return &Metrics{collectors: c}
+}
+
+// WithDatasource returns metrics that are labelled with the UID of a datasource. Release
+// must be called once the datasource instance is disposed.
+func (m *Metrics) WithDatasource(uid string) *Metrics {
+	instancesMu.Lock()
+	defer instancesMu.Unlock()
+	if instances[m.collectors] == nil {
+		instances[m.collectors] = map[string]int{}
+	}
+	instances[m.collectors][uid]++
+	return &Metrics{collectors: m.collectors, datasourceUID: uid}
+}
 
-	return m
+// Release deletes the series of the datasource once no other instance of it records
+// metrics, so deleted datasources do not keep exporting their last values
+func (m *Metrics) Release() {
+	instancesMu.Lock()
+	defer instancesMu.Unlock()
+	counts := instances[m.collectors]
+	if counts[m.datasourceUID] == 0 {
+		return
+	}
+	if counts[m.datasourceUID]--; counts[m.datasourceUID] > 0 {
+		return
+	}
+	delete(counts, m.datasourceUID)
+	for _, vec := range m.all() {
+		vec.DeletePartialMatch(prometheus.Labels{"datasource_uid": m.datasourceUID})
+	}
 }
 
-// RecordRequest records metrics for a request
-func (m *Metrics) RecordRequest(operation string, start time.Time, err error) {
-	m.requestsActive.Inc()
-	defer m.requestsActive.Dec()
-	
+// StartRequest marks a request as active until the returned function is called
+func (m *Metrics) StartRequest() (done func()) {
+	active := m.requestsActive.WithLabelValues(m.datasourceUID)
+	active.Inc()
+	return active.Dec
+}
+
+// RecordRequest records metrics for a finished request
+func (m *Metrics) RecordRequest(ctx context.Context, operation string, start time.Time, err error) {
+	errorType := ""
+	if err != nil {
+		errorType = ErrorType(err)
+	}
+	m.RecordOperation(ctx, operation, start, errorType)
+}
+
+// RecordOperation records metrics for a finished operation that failed with errorType,
+// or succeeded if errorType is empty
+func (m *Metrics) RecordOperation(ctx context.Context, operation string, start time.Time, errorType string) {
 	duration := time.Since(start).Seconds()
 	status := "success"
-	if err != nil {
+	if errorType != "" {
 		status = "error"
-		m.errorsTotal.WithLabelValues(operation, err.Error()).Inc()
+		if !errorTypes[errorType] {
+			errorType = ErrorTypeInternal
+		}
+		m.errorsTotal.WithLabelValues(m.datasourceUID, operation, errorType).Inc()
+	}
+	observe(ctx, m.requestDuration.WithLabelValues(m.datasourceUID, operation, status), duration)
+	m.requestsTotal.WithLabelValues(m.datasourceUID, operation).Inc()
+}
+
+// StartUpstreamRequest marks a call to an upstream endpoint as in flight. The returned
+// function records the call once it finished with statusCode, 0 if no response was
+// received, and a response body of size bytes.
+func (m *Metrics) StartUpstreamRequest(ctx context.Context, endpoint string) (done func(statusCode int, size int)) {
+	start := time.Now()
+	inFlight := m.upstreamInFlight.WithLabelValues(m.datasourceUID, endpoint)
+	inFlight.Inc()
+
+	return func(statusCode int, size int) {
+		inFlight.Dec()
+
+		class := statusClass(statusCode)
+		observe(ctx, m.upstreamDuration.WithLabelValues(m.datasourceUID, endpoint, class), time.Since(start).Seconds())
+		m.upstreamRequests.WithLabelValues(m.datasourceUID, endpoint, class).Inc()
+		if statusCode != 0 {
+			m.upstreamResponseSize.WithLabelValues(m.datasourceUID, endpoint).Observe(float64(size))
+		}
+	}
+}
+
+// RecordAPIKeyUse records an upstream call made with the API key identified by keyID
+func (m *Metrics) RecordAPIKeyUse(keyID string, statusCode int) {
+	m.apiKeyRequests.WithLabelValues(m.datasourceUID, keyID, statusClass(statusCode)).Inc()
+}
+
+// SetAPIKeyAvailable records whether the API key identified by keyID is in rotation
+func (m *Metrics) SetAPIKeyAvailable(keyID string, available bool) {
+	value := 0.0
+	if available {
+		value = 1
+	}
+	m.apiKeyAvailable.WithLabelValues(m.datasourceUID, keyID).Set(value)
+}
+
+// SetCircuitState records the state of the circuit breaker of an upstream endpoint
+func (m *Metrics) SetCircuitState(endpoint string, state int) {
+	m.circuitState.WithLabelValues(m.datasourceUID, endpoint).Set(float64(state))
+}
+
+// RecordRateLimited records a call that the limit named limit held back for the API key
+// identified by keyID
+func (m *Metrics) RecordRateLimited(keyID string, limit string) {
+	m.rateLimited.WithLabelValues(m.datasourceUID, keyID, limit).Inc()
+}
+
+// SetRateLimiterDegraded records whether calls are limited per process because the shared
+// rate limit is unreachable
+func (m *Metrics) SetRateLimiterDegraded(degraded bool) {
+	value := 0.0
+	if degraded {
+		value = 1
+	}
+	m.rateLimiterDegraded.WithLabelValues(m.datasourceUID).Set(value)
+}
+
+// statusClass groups HTTP status codes into 2xx, 4xx, ... to bound the label values
+func statusClass(statusCode int) string {
+	if statusCode < 100 || statusCode > 599 {
+		return "error"
+	}
+	return strconv.Itoa(statusCode/100) + "xx"
+}
+
+// observe records value with the trace ID of ctx as exemplar, so a slow bucket links to
+// one of its traces. Without a sampled trace the value is recorded without exemplar.
+func observe(ctx context.Context, observer prometheus.Observer, value float64) {
+	spanCtx := trace.SpanContextFromContext(ctx)
+	exemplarObserver, ok := observer.(prometheus.ExemplarObserver)
+	if !ok || !spanCtx.IsSampled() {
+		observer.Observe(value)
+		return
 	}
-	m.requestDuration.WithLabelValues(operation, status).Observe(duration)
-	m.requestsTotal.WithLabelValues(operation).Inc()
+	exemplarObserver.ObserveWithExemplar(value, prometheus.Labels{"trace_id": spanCtx.TraceID().String()})
 }
@@ -0,0 +1,149 @@
+package instrumentation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type typedError string
+
+func (e typedError) Error() string     { return string(e) }
+func (e typedError) ErrorType() string { return string(e) }
+
+func TestNewMetricsRegistersOnce(t *testing.T) {
+	first := NewMetrics("metrics_test").WithDatasource("a")
+	second := NewMetrics("metrics_test").WithDatasource("b")
+
+	first.RecordRequest(context.Background(), "query_data", time.Now(), nil)
+	second.RecordRequest(context.Background(), "query_data", time.Now(), nil)
+	second.RecordRequest(context.Background(), "query_data", time.Now(), nil)
+
+	if n := testutil.ToFloat64(first.requestsTotal.WithLabelValues("a", "query_data")); n != 1 {
+		t.Errorf("expected 1 request for datasource a, got %v", n)
+	}
+	if n := testutil.ToFloat64(second.requestsTotal.WithLabelValues("b", "query_data")); n != 2 {
+		t.Errorf("expected 2 requests for datasource b, got %v", n)
+	}
+}
+
+func TestErrorType(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{typedError(ErrorTypeNotFound), ErrorTypeNotFound},
+		{fmt.Errorf("wrapped: %w", typedError(ErrorTypeRateLimited)), ErrorTypeRateLimited},
+		{typedError("city not found: Marburg"), ErrorTypeInternal},
+		{context.DeadlineExceeded, ErrorTypeTimeout},
+		{context.Canceled, ErrorTypeCanceled},
+		{errors.New("unexpected end of JSON input"), ErrorTypeInternal},
+	} {
+		if got := ErrorType(tc.err); got != tc.want {
+			t.Errorf("%v: expected %q, got %q", tc.err, tc.want, got)
+		}
+	}
+}
+
+func TestRecordOperationBoundsErrorTypes(t *testing.T) {
+	m := NewMetrics("metrics_test").WithDatasource("c")
+	m.RecordOperation(context.Background(), "query", time.Now(), "Get \"https://api.openweathermap.org\": dial tcp: i/o timeout")
+
+	if n := testutil.ToFloat64(m.errorsTotal.WithLabelValues("c", "query", ErrorTypeInternal)); n != 1 {
+		t.Errorf("expected the unknown error type to be recorded as internal, got %v", n)
+	}
+}
+
+func TestStartRequestTracksActiveRequests(t *testing.T) {
+	m := NewMetrics("metrics_test").WithDatasource("d")
+	active := m.requestsActive.WithLabelValues("d")
+
+	done := m.StartRequest()
+	if n := testutil.ToFloat64(active); n != 1 {
+		t.Errorf("expected 1 active request, got %v", n)
+	}
+	done()
+	if n := testutil.ToFloat64(active); n != 0 {
+		t.Errorf("expected no active requests, got %v", n)
+	}
+}
+
+func TestStartUpstreamRequest(t *testing.T) {
+	m := NewMetrics("metrics_test").WithDatasource("e")
+
+	done := m.StartUpstreamRequest(context.Background(), "weather")
+	if n := testutil.ToFloat64(m.upstreamInFlight.WithLabelValues("e", "weather")); n != 1 {
+		t.Errorf("expected 1 upstream call in flight, got %v", n)
+	}
+	done(429, 64)
+	m.StartUpstreamRequest(context.Background(), "weather")(0, 0)
+
+	if n := testutil.ToFloat64(m.upstreamInFlight.WithLabelValues("e", "weather")); n != 0 {
+		t.Errorf("expected no upstream calls in flight, got %v", n)
+	}
+	if n := testutil.ToFloat64(m.upstreamRequests.WithLabelValues("e", "weather", "4xx")); n != 1 {
+		t.Errorf("expected 1 call with a 4xx status, got %v", n)
+	}
+	if n := testutil.ToFloat64(m.upstreamRequests.WithLabelValues("e", "weather", "error")); n != 1 {
+		t.Errorf("expected 1 call without a response, got %v", n)
+	}
+	if n := testutil.CollectAndCount(m.upstreamResponseSize, "grafana_plugin_metrics_test_upstream_response_size_bytes"); n == 0 {
+		t.Error("expected the response size to be observed")
+	}
+}
+
+func TestObserveAddsTraceExemplar(t *testing.T) {
+	m := NewMetrics("metrics_test").WithDatasource("f")
+	traceID := trace.TraceID{1, 2, 3}
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     trace.SpanID{4},
+		TraceFlags: trace.FlagsSampled,
+	}))
+
+	m.RecordOperation(ctx, "exemplar", time.Now(), "")
+
+	var metric dto.Metric
+	if err := m.requestDuration.WithLabelValues("f", "exemplar", "success").(prometheus.Metric).Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	var exemplars []*dto.Exemplar
+	for _, bucket := range metric.GetHistogram().GetBucket() {
+		if bucket.GetExemplar() != nil {
+			exemplars = append(exemplars, bucket.GetExemplar())
+		}
+	}
+	if len(exemplars) != 1 || exemplars[0].GetLabel()[0].GetValue() != traceID.String() {
+		t.Errorf("expected an exemplar with trace ID %s, got %v", traceID, exemplars)
+	}
+}
+
+func TestReleaseDeletesSeriesOfLastInstance(t *testing.T) {
+	first := NewMetrics("metrics_test").WithDatasource("released")
+	second := NewMetrics("metrics_test").WithDatasource("released")
+	other := NewMetrics("metrics_test").WithDatasource("kept")
+	for _, m := range []*Metrics{first, other} {
+		m.RecordRequest(context.Background(), "query_data", time.Now(), nil)
+		m.SetCircuitState("weather", 2)
+	}
+
+	first.Release()
+	if n := testutil.CollectAndCount(first.circuitState); n != 2 {
+		t.Fatalf("expected the series to be kept while another instance uses them, got %d", n)
+	}
+	second.Release()
+	if n := testutil.CollectAndCount(first.circuitState); n != 1 {
+		t.Errorf("expected only the series of the other datasource, got %d", n)
+	}
+	if n := testutil.ToFloat64(other.requestsTotal.WithLabelValues("kept", "query_data")); n != 1 {
+		t.Errorf("expected the requests of the other datasource to be kept, got %v", n)
+	}
+	second.Release()
+}
@@ -0,0 +1,116 @@
+package instrumentation
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Redacted replaces secrets in logs, errors, notices and spans
+const Redacted = "API_KEY_HIDDEN"
+
+// minSecretLength keeps very short secrets from redacting unrelated text
+const minSecretLength = 4
+
+// appidPattern matches the value of the appid parameter, also when it is URL encoded
+var appidPattern = regexp.MustCompile(`(?i)(appid(?:=|%3D))[^&\s"'%]+`)
+
+// Redactor scrubs secrets and appid parameters from everything that leaves the plugin.
+// A nil Redactor only scrubs appid parameters.
+type Redactor struct {
+	mu      sync.RWMutex
+	secrets []string
+}
+
+// NewRedactor returns a Redactor for the given secrets. Empty secrets are ignored.
+func NewRedactor(secrets ...string) *Redactor {
+	r := &Redactor{}
+	r.Add(secrets...)
+	return r
+}
+
+// Add redacts more secrets, for example rotated API keys
+func (r *Redactor) Add(secrets ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, secret := range secrets {
+		if len(secret) >= minSecretLength && !slices.Contains(r.secrets, secret) {
+			r.secrets = append(r.secrets, secret)
+		}
+	}
+}
+
+// String returns s with all secrets replaced
+func (r *Redactor) String(s string) string {
+	s = appidPattern.ReplaceAllString(s, "${1}"+Redacted)
+	if r == nil {
+		return s
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, Redacted)
+	}
+	return s
+}
+
+// Error returns an error with a redacted message. It still matches err with errors.Is
+// and errors.As.
+func (r *Redactor) Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	if clean := r.String(msg); clean != msg {
+		return &redactedError{msg: clean, err: err}
+	}
+	return err
+}
+
+// Args redacts the values of log arguments
+func (r *Redactor) Args(args []interface{}) []interface{} {
+	redacted := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case string:
+			redacted[i] = r.String(v)
+		case error:
+			redacted[i] = r.String(v.Error())
+		case fmt.Stringer:
+			redacted[i] = r.String(v.String())
+		default:
+			redacted[i] = arg
+		}
+	}
+	return redacted
+}
+
+// Attributes redacts the string values of span attributes
+func (r *Redactor) Attributes(attrs []attribute.KeyValue) []attribute.KeyValue {
+	redacted := make([]attribute.KeyValue, len(attrs))
+	for i, attr := range attrs {
+		if attr.Value.Type() == attribute.STRING {
+			attr.Value = attribute.StringValue(r.String(attr.Value.AsString()))
+		}
+		redacted[i] = attr
+	}
+	return redacted
+}
+
+// redactedError is an error whose message had secrets removed
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
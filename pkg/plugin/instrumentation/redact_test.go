@@ -0,0 +1,88 @@
+package instrumentation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/plugin/instrumentation/logtest"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const testSecret = "0123456789abcdef"
+
+func TestRedactorString(t *testing.T) {
+	r := NewRedactor(testSecret)
+	for _, s := range []string{
+		"https://api.openweathermap.org/data/2.5/weather?appid=" + testSecret + "&q=Marburg",
+		"https://api.openweathermap.org/data/2.5/weather?q=Marburg&APPID=" + testSecret,
+		"/proxy?target=" + url.QueryEscape("/weather?appid="+testSecret),
+		`{"cod": 401, "message": "Invalid API key ` + testSecret + `"}`,
+	} {
+		got := r.String(s)
+		if strings.Contains(got, testSecret) || !strings.Contains(got, Redacted) {
+			t.Errorf("%s: secret not redacted: %s", s, got)
+		}
+	}
+
+	if got := (*Redactor)(nil).String("weather?appid=" + testSecret); strings.Contains(got, testSecret) {
+		t.Errorf("nil redactor kept the appid parameter: %s", got)
+	}
+	if got := NewRedactor("", "ab").String("a tab"); got != "a tab" {
+		t.Errorf("short secrets must be ignored, got %s", got)
+	}
+}
+
+func TestRedactorError(t *testing.T) {
+	r := NewRedactor(testSecret)
+	cause := errors.New("upstream unavailable")
+	err := fmt.Errorf("Get \"https://api.openweathermap.org/data/2.5/weather?appid=%s\": %w", testSecret, cause)
+
+	redacted := r.Error(err)
+	if strings.Contains(redacted.Error(), testSecret) {
+		t.Errorf("secret not redacted: %v", redacted)
+	}
+	if !errors.Is(redacted, cause) {
+		t.Error("redacted error no longer matches its cause")
+	}
+	if clean := errors.New("no secrets"); r.Error(clean) != clean {
+		t.Error("errors without secrets should be returned as is")
+	}
+	if r.Error(nil) != nil {
+		t.Error("nil must stay nil")
+	}
+}
+
+func TestLoggerRedactsSecrets(t *testing.T) {
+	recorder := logtest.New()
+	logger := WrapLogger(recorder).WithRedactor(NewRedactor(testSecret))
+
+	ctx := WithLogAttributes(context.Background(), "url", "weather?appid="+testSecret)
+	logger.With("key", testSecret).FromContext(ctx).Error("Request failed for "+testSecret,
+		"error", errors.New("bad key "+testSecret),
+		"url", &url.URL{Path: "/weather", RawQuery: "appid=" + testSecret})
+
+	if entries := recorder.Entries(); len(entries) != 1 || strings.Contains(entries[0], testSecret) {
+		t.Errorf("secret leaked into the log: %v", entries)
+	}
+}
+
+func TestTracingHelperRedactsSecrets(t *testing.T) {
+	helper, recorder := newRecordingTracingHelper()
+	helper = helper.WithRedactor(NewRedactor(testSecret))
+
+	ctx, span := helper.StartSpan(context.Background(), "upstream_request", attribute.String("url", "weather?appid="+testSecret))
+	helper.SetAttributes(ctx, attribute.String("body", testSecret))
+	helper.AddEvent(ctx, EventRetry, attribute.String("error", "bad key "+testSecret))
+	helper.RecordError(ctx, errors.New("bad key "+testSecret))
+	span.End()
+
+	got := recorder.Ended()[0]
+	recorded := fmt.Sprint(got.Attributes(), got.Events(), got.Status())
+	if strings.Contains(recorded, testSecret) {
+		t.Errorf("secret leaked into the span: %s", recorded)
+	}
+}
@@ -4,12 +4,21 @@ import (
 	"context"
 
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
 	"go.opentelemetry.io/otel/trace/noop"
 )
 
+// Span events for decisions that change how a query is answered
+const (
+	EventCacheHit  = "cache.hit"
+	EventCacheMiss = "cache.miss"
+	EventRetry     = "retry"
+)
+
 type TracingHelper struct {
-	tracer trace.Tracer
+	tracer   trace.Tracer
+	redactor *Redactor
 }
 
 func NewTracingHelper(tracer trace.Tracer) *TracingHelper {
@@ -21,9 +30,68 @@ func NewTracingHelper(tracer trace.Tracer) *TracingHelper {
 	}
 }
 
+// WithRedactor returns a TracingHelper that removes the secrets of redactor from span
+// attributes, events and errors. Without a redactor only appid parameters are removed.
+func (t *TracingHelper) WithRedactor(redactor *Redactor) *TracingHelper {
+	return &TracingHelper{
+		tracer:   t.tracer,
+		redactor: redactor,
+	}
+}
+
 // StartSpan starts a new span with optional attributes
 func (t *TracingHelper) StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
-	return t.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+	return t.tracer.Start(ctx, name, trace.WithAttributes(t.redactor.Attributes(attrs)...))
+}
+
+// SetAttributes adds attributes to the current span of ctx
+func (t *TracingHelper) SetAttributes(ctx context.Context, attrs ...attribute.KeyValue) {
+	trace.SpanFromContext(ctx).SetAttributes(t.redactor.Attributes(attrs)...)
+}
+
+// QueryAttributes describe a query on its span. Empty values are left out.
+type QueryAttributes struct {
+	City   string
+	Lat    *float64
+	Lon    *float64
+	Metric string
+	Format string
+	Units  string
+}
+
+// SetQueryAttributes adds the attributes of a query to the current span of ctx
+func (t *TracingHelper) SetQueryAttributes(ctx context.Context, q QueryAttributes) {
+	var attrs []attribute.KeyValue
+	for _, kv := range []struct{ key, value string }{
+		{"query.city", q.City},
+		{"query.metric", q.Metric},
+		{"query.format", q.Format},
+		{"query.units", q.Units},
+	} {
+		if kv.value != "" {
+			attrs = append(attrs, attribute.String(kv.key, kv.value))
+		}
+	}
+	if q.Lat != nil && q.Lon != nil {
+		attrs = append(attrs, attribute.Float64("query.lat", *q.Lat), attribute.Float64("query.lon", *q.Lon))
+	}
+	t.SetAttributes(ctx, attrs...)
+}
+
+// RecordError records err on the current span of ctx and marks the span as failed
+func (t *TracingHelper) RecordError(ctx context.Context, err error) {
+	if err == nil {
+		return
+	}
+	err = t.redactor.Error(err)
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// AddEvent adds an event like EventCacheHit or EventRetry to the current span of ctx
+func (t *TracingHelper) AddEvent(ctx context.Context, name string, attrs ...attribute.KeyValue) {
+	trace.SpanFromContext(ctx).AddEvent(name, trace.WithAttributes(t.redactor.Attributes(attrs)...))
 }
 
 // GetSpanContext retrieves the span context from the given context
@@ -0,0 +1,70 @@
+package instrumentation
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newRecordingTracingHelper() (*TracingHelper, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	return NewTracingHelper(provider.Tracer("test")), recorder
+}
+
+func TestTracingHelperRecordsQueries(t *testing.T) {
+	helper, recorder := newRecordingTracingHelper()
+
+	lat, lon := 50.81, 8.77
+	ctx, span := helper.StartSpan(context.Background(), "process_query")
+	helper.SetQueryAttributes(ctx, QueryAttributes{City: "Marburg", Lat: &lat, Lon: &lon, Metric: "main", Units: "metric"})
+	helper.AddEvent(ctx, EventRetry, attribute.Int("attempt", 1))
+	helper.RecordError(ctx, errors.New("location not found"))
+	span.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	got := spans[0]
+
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range got.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	if attrs["query.city"].AsString() != "Marburg" || attrs["query.metric"].AsString() != "main" ||
+		attrs["query.units"].AsString() != "metric" || attrs["query.lat"].AsFloat64() != lat {
+		t.Errorf("unexpected attributes %v", got.Attributes())
+	}
+	if _, ok := attrs["query.format"]; ok {
+		t.Error("expected empty attributes to be left out")
+	}
+
+	if got.Status().Code != codes.Error || got.Status().Description != "location not found" {
+		t.Errorf("expected an error status, got %v", got.Status())
+	}
+	var events []string
+	for _, event := range got.Events() {
+		events = append(events, event.Name)
+	}
+	if len(events) != 2 || events[0] != EventRetry || events[1] != "exception" {
+		t.Errorf("expected a retry and an exception event, got %v", events)
+	}
+}
+
+func TestRecordErrorIgnoresNil(t *testing.T) {
+	helper, recorder := newRecordingTracingHelper()
+
+	ctx, span := helper.StartSpan(context.Background(), "process_query")
+	helper.RecordError(ctx, nil)
+	span.End()
+
+	if status := recorder.Ended()[0].Status(); status.Code != codes.Unset {
+		t.Errorf("expected no status, got %v", status)
+	}
+}
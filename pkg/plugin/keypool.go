@@ -0,0 +1,152 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/plugin/instrumentation"
+)
+
+// How long a key is taken out of rotation. A rejected key is likely revoked or not yet
+// active, a rate limited key can be used again once its quota window has passed.
+const (
+	unauthorizedCooldown = 10 * time.Minute
+	rateLimitedCooldown  = time.Minute
+)
+
+// pooledKey is an API key with its rotation state
+type pooledKey struct {
+	key string
+	// id identifies the key in logs and metrics without revealing it
+	id        string
+	coolUntil time.Time
+}
+
+// keyPool spreads upstream calls across the API keys of a datasource. Keys that OpenWeather
+// rejects or rate limits are skipped until their cooldown has passed. The keys of a pool
+// do not change, a changed key file results in a new pool.
+type keyPool struct {
+	mu      sync.Mutex
+	keys    []*pooledKey
+	next    int
+	metrics *instrumentation.Metrics
+	now     func() time.Time
+}
+
+func newKeyPool(keys []string, metrics *instrumentation.Metrics) *keyPool {
+	return newKeyPoolFrom(nil, keys, metrics)
+}
+
+// newKeyPoolFrom returns a pool of keys. Keys that were in previous keep their cooldown.
+func newKeyPoolFrom(previous *keyPool, keys []string, metrics *instrumentation.Metrics) *keyPool {
+	p := &keyPool{metrics: metrics, now: time.Now}
+	coolUntil := map[string]time.Time{}
+	if previous != nil {
+		p.now = previous.now
+		previous.mu.Lock()
+		for _, k := range previous.keys {
+			coolUntil[k.key] = k.coolUntil
+		}
+		previous.mu.Unlock()
+	}
+	for _, key := range keys {
+		k := &pooledKey{key: key, id: keyID(key), coolUntil: coolUntil[key]}
+		p.keys = append(p.keys, k)
+		metrics.SetAPIKeyAvailable(k.id, !p.now().Before(k.coolUntil))
+	}
+	return p
+}
+
+// keyID returns a short, stable identifier of a key
+func keyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:4])
+}
+
+// values returns the keys of the pool
+func (p *keyPool) values() []string {
+	values := make([]string, 0, len(p.keys))
+	for _, k := range p.keys {
+		values = append(values, k.key)
+	}
+	return values
+}
+
+// size returns the number of keys in the pool
+func (p *keyPool) size() int {
+	return len(p.keys)
+}
+
+// pick returns the next available key in round robin order that accept allows, any key
+// if accept is nil. If every allowed key is cooling down, the key that becomes available
+// first is returned, so requests still have a chance. Without allowed keys pick returns nil.
+func (p *keyPool) pick(accept func(*pooledKey) bool) *pooledKey {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	var soonest *pooledKey
+	for i := 0; i < len(p.keys); i++ {
+		k := p.keys[(p.next+i)%len(p.keys)]
+		if accept != nil && !accept(k) {
+			continue
+		}
+		if !now.Before(k.coolUntil) {
+			p.next = (p.next + i + 1) % len(p.keys)
+			return k
+		}
+		if soonest == nil || k.coolUntil.Before(soonest.coolUntil) {
+			soonest = k
+		}
+	}
+	return soonest
+}
+
+// available reports whether a key other than k that accept allows can be used right now
+func (p *keyPool) available(k *pooledKey, accept func(*pooledKey) bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	for _, other := range p.keys {
+		if other != k && !now.Before(other.coolUntil) && (accept == nil || accept(other)) {
+			return true
+		}
+	}
+	return false
+}
+
+// report records the outcome of a call made with k. Keys that were rejected or rate
+// limited are taken out of rotation.
+func (p *keyPool) report(k *pooledKey, err error) {
+	statusCode := http.StatusOK
+	if err != nil {
+		statusCode = 0
+		var upstreamErr *UpstreamError
+		if errors.As(err, &upstreamErr) {
+			statusCode = upstreamErr.StatusCode
+		}
+	}
+
+	var cooldown time.Duration
+	switch {
+	case errors.Is(err, ErrUnauthorized):
+		cooldown = unauthorizedCooldown
+	case errors.Is(err, ErrRateLimited):
+		cooldown = rateLimitedCooldown
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.metrics.RecordAPIKeyUse(k.id, statusCode)
+	if cooldown > 0 {
+		k.coolUntil = p.now().Add(cooldown)
+		p.metrics.SetAPIKeyAvailable(k.id, false)
+	} else if !k.coolUntil.IsZero() {
+		k.coolUntil = time.Time{}
+		p.metrics.SetAPIKeyAvailable(k.id, true)
+	}
+}
@@ -0,0 +1,125 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/models"
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/plugin/instrumentation"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestKeyPoolRotationAndCooldown(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	pool := newKeyPool([]string{"key-a", "key-b", "key-c"}, instrumentation.NewMetrics("openweather_test").WithDatasource("test"))
+	pool.now = func() time.Time { return now }
+
+	var picked []string
+	for i := 0; i < 4; i++ {
+		picked = append(picked, pool.pick(nil).key)
+	}
+	if want := []string{"key-a", "key-b", "key-c", "key-a"}; !slices.Equal(picked, want) {
+		t.Errorf("expected round robin %v, got %v", want, picked)
+	}
+
+	a, b, c := pool.keys[0], pool.keys[1], pool.keys[2]
+	pool.report(b, newStatusError(EndpointWeather, http.StatusTooManyRequests, ""))
+	pool.report(c, newStatusError(EndpointWeather, http.StatusUnauthorized, ""))
+	for i := 0; i < 3; i++ {
+		if k := pool.pick(nil); k != a {
+			t.Fatalf("expected only the healthy key while the others cool down, got %s", k.key)
+		}
+	}
+	if !pool.available(b, nil) || pool.available(a, nil) {
+		t.Error("expected only key-a to be available")
+	}
+
+	// Every key cooling down: the one that is available first is tried
+	pool.report(a, newStatusError(EndpointWeather, http.StatusUnauthorized, ""))
+	if k := pool.pick(nil); k != b {
+		t.Errorf("expected the rate limited key with the shortest cooldown, got %s", k.key)
+	}
+
+	now = now.Add(rateLimitedCooldown)
+	if k := pool.pick(nil); k != b {
+		t.Errorf("expected the rate limited key back in rotation, got %s", k.key)
+	}
+	now = now.Add(unauthorizedCooldown)
+	pool.report(b, nil)
+	if got := apiKeyAvailable(t, b.id); got != 1 {
+		t.Errorf("expected key-b to be reported available, got %v", got)
+	}
+
+	// Updating the keys keeps the cooldown of keys that stay
+	pool.report(a, newStatusError(EndpointWeather, http.StatusUnauthorized, ""))
+	updated := newKeyPoolFrom(pool, []string{"key-a", "key-d"}, pool.metrics)
+	if len(updated.keys) != 2 || !updated.keys[0].coolUntil.Equal(a.coolUntil) || !updated.keys[1].coolUntil.IsZero() {
+		t.Error("expected key-a to keep its cooldown in the new pool")
+	}
+	if len(pool.keys) != 3 {
+		t.Error("expected the previous pool to be unchanged")
+	}
+}
+
+func TestFetchFailsOverToAnotherKey(t *testing.T) {
+	server, requests := newTestServer(t, withKeyStatus(map[string]int{
+		"limited-key": http.StatusTooManyRequests,
+		"revoked-key": http.StatusUnauthorized,
+	}))
+
+	ds := newTestDatasourceWithSettings(func(s *models.PluginSettings) {
+		s.APIRoot = server.URL
+		s.Secrets.ApiKeys = []string{"limited-key", "revoked-key", "good-key"}
+	})
+
+	for i := 0; i < 3; i++ {
+		// A different city per query, so none is answered from the cache
+		resp, err := ds.QueryData(context.Background(), queryTypeRequest(
+			backend.DataQuery{RefID: "A", QueryType: QueryTypeCurrent, JSON: []byte(fmt.Sprintf(`{"city": "Marburg %d"}`, i))},
+		))
+		if err != nil || resp.Responses["A"].Error != nil {
+			t.Fatalf("expected the query to fail over to the working key, got %v %v", err, resp.Responses["A"].Error)
+		}
+	}
+
+	// The rejected keys are tried once, after that they cool down
+	want := []string{"limited-key", "revoked-key", "good-key", "good-key", "good-key"}
+	if used := requests.keys(); !slices.Equal(used, want) {
+		t.Errorf("expected keys %v, got %v", want, used)
+	}
+	if got := apiKeyAvailable(t, keyID("limited-key")); got != 0 {
+		t.Errorf("expected the rate limited key to be reported unavailable, got %v", got)
+	}
+}
+
+func TestFetchWithAllKeysRejected(t *testing.T) {
+	server, requests := newTestServer(t, withStatus(map[string]int{"/data/2.5/weather": http.StatusUnauthorized}))
+
+	ds := newTestDatasourceWithSettings(func(s *models.PluginSettings) {
+		s.APIRoot = server.URL
+		s.Secrets.ApiKeys = []string{"key-1", "key-2"}
+	})
+
+	var current CurrentWeatherResponse
+	err := ds.fetch(context.Background(), EndpointWeather, nil, &current)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if n := len(requests.paths()); n != 2 {
+		t.Errorf("expected each key to be tried once, got %d requests", n)
+	}
+}
+
+// apiKeyAvailable returns the availability gauge of a key of the test datasource
+func apiKeyAvailable(t *testing.T, id string) float64 {
+	t.Helper()
+	metric := testMetric(t, "api_key_available", map[string]string{"key_id": id})
+	if metric == nil {
+		return -1
+	}
+	return metric.GetGauge().GetValue()
+}
@@ -0,0 +1,57 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+)
+
+// errDisposed is returned for work that is started after the datasource was disposed
+var errDisposed = fmt.Errorf("datasource instance is disposed: %w", context.Canceled)
+
+// start registers a unit of work, like a request or a background worker, with the
+// lifecycle of the datasource. The returned context is cancelled when ctx is done or
+// the datasource is disposed, and Dispose waits until done is called.
+func (d *Datasource) start(ctx context.Context) (context.Context, func(), error) {
+	d.lifecycleMu.Lock()
+	defer d.lifecycleMu.Unlock()
+	if d.ctx.Err() != nil {
+		return nil, nil, errDisposed
+	}
+	d.inflight.Add(1)
+
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(d.ctx, cancel)
+	done := func() {
+		stop()
+		cancel()
+		d.inflight.Done()
+	}
+	return ctx, done, nil
+}
+
+// Dispose here tells plugin SDK that plugin wants to clean up resources when a new instance
+// created. As soon as datasource settings change detected by SDK old datasource instance will
+// be disposed and a new one will be created using NewDatasource factory function.
+//
+// Dispose cancels all in-flight work, waits until it has returned and closes idle connections,
+// the cache backend and the Redis client. The metric series of the datasource are deleted
+// unless an instance with newer settings replaced this one.
+func (d *Datasource) Dispose() {
+	d.logger.Info("Disposing datasource instance")
+
+	d.lifecycleMu.Lock()
+	d.cancel()
+	d.lifecycleMu.Unlock()
+
+	d.inflight.Wait()
+	d.httpClient.CloseIdleConnections()
+	if err := d.cache.close(); err != nil {
+		d.logger.Warn("Could not close the response cache", "error", err)
+	}
+	if d.redis != nil {
+		if err := d.redis.Close(); err != nil {
+			d.logger.Warn("Could not close the Redis client", "error", err)
+		}
+	}
+	d.metrics.Release()
+}
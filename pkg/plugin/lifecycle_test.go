@@ -0,0 +1,128 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/plugin/instrumentation"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// waitFor polls cond until it holds or the timeout expires
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDisposeCancelsInflightQueries(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+
+	received := make(chan struct{}, 1)
+	server, _ := newTestServer(t, withHandler(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-r.Context().Done()
+	}))
+	ds := newTestDatasourceWithServer(server)
+
+	result := make(chan *backend.QueryDataResponse)
+	go func() {
+		resp, _ := ds.QueryData(context.Background(), queryTypeRequest(
+			backend.DataQuery{RefID: "A", QueryType: QueryTypeCurrent, JSON: []byte(`{"city": "Marburg"}`)},
+		))
+		result <- resp
+	}()
+	<-received
+
+	ds.Dispose()
+
+	select {
+	case resp := <-result:
+		if resp.Responses["A"].Error == nil {
+			t.Error("expected the cancelled query to fail")
+		}
+	default:
+		t.Fatal("Dispose returned before the in-flight query")
+	}
+
+	if _, err := ds.QueryData(context.Background(), queryTypeRequest()); !errors.Is(err, errDisposed) {
+		t.Errorf("expected queries after Dispose to fail with %v, got %v", errDisposed, err)
+	}
+
+	server.Close()
+	waitFor(t, "goroutines to exit", func() bool {
+		return runtime.NumGoroutine() <= baseline
+	})
+}
+
+func TestDisposeClosesIdleConnections(t *testing.T) {
+	server, requests := newTestServer(t)
+	ds := newTestDatasourceWithServer(server)
+
+	resp, err := ds.QueryData(context.Background(), queryTypeRequest(
+		backend.DataQuery{RefID: "A", QueryType: QueryTypeCurrent, JSON: []byte(`{"city": "Marburg"}`)},
+	))
+	if err != nil || resp.Responses["A"].Error != nil {
+		t.Fatalf("unexpected error %v %v", err, resp.Responses["A"].Error)
+	}
+
+	ds.Dispose()
+
+	waitFor(t, "the idle connection to be closed", func() bool {
+		return requests.closedConns.Load() > 0
+	})
+}
+
+func TestDisposeDeletesMetricSeries(t *testing.T) {
+	server, _ := newTestServer(t)
+	metrics := instrumentation.NewMetrics("openweather_test")
+	newInstance := func() *Datasource {
+		return newDatasource(newTestDatasourceWithServer(server).settings, instrumentation.WrapLogger(log.New()),
+			instrumentation.NewTracingHelper(nil), metrics.WithDatasource("disposed"))
+	}
+	series := func() int {
+		families, err := prometheus.DefaultGatherer.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for _, family := range families {
+			for _, metric := range family.GetMetric() {
+				for _, label := range metric.GetLabel() {
+					if label.GetName() == "datasource_uid" && label.GetValue() == "disposed" {
+						n++
+					}
+				}
+			}
+		}
+		return n
+	}
+
+	old := newInstance()
+	if res := queryCurrent(t, old); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	// The instance with the changed settings is created before the old one is disposed
+	replacement := newInstance()
+	old.Dispose()
+	if series() == 0 {
+		t.Fatal("expected the series to be kept while a replacement records them")
+	}
+
+	replacement.Dispose()
+	if n := series(); n != 0 {
+		t.Errorf("expected the series of the disposed datasource to be deleted, got %d", n)
+	}
+}
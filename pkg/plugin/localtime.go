@@ -0,0 +1,90 @@
+package plugin
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const localTimeLayout = "2006-01-02 15:04"
+
+// cityLocation returns a fixed zone for the timezone offset (in seconds) reported by OpenWeather
+func cityLocation(city CityInfo) *time.Location {
+	return time.FixedZone(formatUTCOffset(city.Timezone), city.Timezone)
+}
+
+// formatUTCOffset formats an offset in seconds as "+hh:mm"
+func formatUTCOffset(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	return fmt.Sprintf("%s%02d:%02d", sign, offset/3600, (offset%3600)/60)
+}
+
+// isDaytime reports whether the forecast item falls into daylight. The part of day
+// reported by OpenWeather is preferred, sunrise and sunset of the city are used otherwise.
+func isDaytime(item ForecastItem, city CityInfo) bool {
+	switch item.Sys.Pod {
+	case "d":
+		return true
+	case "n":
+		return false
+	}
+
+	if city.Sunrise == 0 || city.Sunset == 0 {
+		return false
+	}
+
+	// Sunrise and sunset are only reported for a single day, so compare the time of day
+	secondOfDay := func(ts int64) int64 {
+		return ((ts+int64(city.Timezone))%86400 + 86400) % 86400
+	}
+	t := secondOfDay(item.Dt)
+	return t >= secondOfDay(city.Sunrise) && t < secondOfDay(city.Sunset)
+}
+
+// localTime returns the timestamp of the item. With shift the instant is moved by the
+// city offset, so daily boundaries of a dashboard in UTC line up with the local days.
+func localTime(item ForecastItem, city CityInfo, shift bool) time.Time {
+	ts := time.Unix(item.Dt, 0)
+	if shift {
+		ts = ts.Add(time.Duration(city.Timezone) * time.Second)
+	}
+	return ts.UTC()
+}
+
+// localTimeColumns collects the local time fields for every item of a forecast
+type localTimeColumns struct {
+	localTimes []string
+	offsets    []string
+	daytime    []bool
+}
+
+func (c *localTimeColumns) append(item ForecastItem, city CityInfo) {
+	loc := cityLocation(city)
+	c.localTimes = append(c.localTimes, time.Unix(item.Dt, 0).In(loc).Format(localTimeLayout))
+	c.offsets = append(c.offsets, loc.String())
+	c.daytime = append(c.daytime, isDaytime(item, city))
+}
+
+func (c *localTimeColumns) fields() []*data.Field {
+	dayField := data.NewField("is_day", nil, c.daytime)
+	dayField.Config = &data.FieldConfig{
+		DisplayName: "Day",
+		Mappings: data.ValueMappings{
+			data.ValueMapper{
+				"true":  {Text: "Day", Color: "yellow", Index: 0},
+				"false": {Text: "Night", Color: "dark-blue", Index: 1},
+			},
+		},
+	}
+
+	return []*data.Field{
+		data.NewField("local_time", nil, c.localTimes),
+		data.NewField("utc_offset", nil, c.offsets),
+		dayField,
+	}
+}
@@ -0,0 +1,130 @@
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// fallbackCounter counts the items of a frame where a value was missing and a fallback used
+type fallbackCounter struct {
+	total          int
+	missingWeather int
+	missingRain    int
+}
+
+func (c *fallbackCounter) add(item ForecastItem, qm queryModel) {
+	c.total++
+	if len(item.Weather) == 0 {
+		c.missingWeather++
+	}
+	if qm.Metric == "rain" && item.Rain == nil {
+		c.missingRain++
+	}
+}
+
+// notices returns a notice for every fallback that was used
+func (c *fallbackCounter) notices() []data.Notice {
+	var notices []data.Notice
+	if c.missingWeather > 0 {
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text: fmt.Sprintf("%d of %d items have no weather condition, their description is empty",
+				c.missingWeather, c.total),
+		})
+	}
+	if c.missingRain > 0 {
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text: fmt.Sprintf("%d of %d items report no rain volume, 0 is used instead",
+				c.missingRain, c.total),
+		})
+	}
+	return notices
+}
+
+// coverageNotices reports the parts of the time range that the data does not cover.
+// Gaps up to step, the interval between two items, are expected and not reported. shift
+// is how far the times of the frame were moved from UTC, see localTime; the time range is
+// moved along, so both are compared in the same time base.
+func coverageNotices(frame *data.Frame, timeRange backend.TimeRange, step time.Duration, shift time.Duration) []data.Notice {
+	if frame.Rows() == 0 || timeRange.To.IsZero() {
+		return nil
+	}
+	timeRange.From = timeRange.From.Add(shift)
+	timeRange.To = timeRange.To.Add(shift)
+
+	first, ok := frame.Fields[0].ConcreteAt(0)
+	if !ok {
+		return nil
+	}
+	last, ok := frame.Fields[0].ConcreteAt(frame.Rows() - 1)
+	if !ok {
+		return nil
+	}
+
+	var notices []data.Notice
+	if end := last.(time.Time); timeRange.To.Sub(end) > step {
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("Data is only available until %s, the rest of the time range is empty", end.Format(time.RFC3339)),
+		})
+	}
+	if start := first.(time.Time); start.Sub(timeRange.From) > step {
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("Data is only available from %s, the start of the time range is empty", start.Format(time.RFC3339)),
+		})
+	}
+	return notices
+}
+
+// addPartialResultNotices warns the successful queries of a response when other queries
+// failed, so a panel that shows several locations tells which of them are missing
+func addPartialResultNotices(response *backend.QueryDataResponse) {
+	var failed []string
+	for refID, res := range response.Responses {
+		if res.Error != nil {
+			failed = append(failed, fmt.Sprintf("query %s failed: %v", refID, res.Error))
+		}
+	}
+	if len(failed) == 0 || len(failed) == len(response.Responses) {
+		return
+	}
+	sort.Strings(failed)
+
+	notice := data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text: fmt.Sprintf("%d of %d queries failed, the panel only shows part of the locations: %s",
+			len(failed), len(response.Responses), strings.Join(failed, "; ")),
+	}
+	for _, res := range response.Responses {
+		if res.Error != nil {
+			continue
+		}
+		for _, frame := range res.Frames {
+			frame.AppendNotices(notice)
+		}
+	}
+}
+
+// truncatedRangeNotice reports that the time range of a query was shortened
+func truncatedRangeNotice(requested backend.TimeRange, used backend.TimeRange) data.Notice {
+	return data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text: fmt.Sprintf("The time range was truncated to %s - %s, the requested range starting at %s is too long",
+			used.From.Format(time.RFC3339), used.To.Format(time.RFC3339), requested.From.Format(time.RFC3339)),
+	}
+}
+
+// defaultLocationNotice reports that the query used the default location of the datasource
+func defaultLocationNotice(location string) data.Notice {
+	return data.Notice{
+		Severity: data.NoticeSeverityInfo,
+		Text:     fmt.Sprintf("The query has no location, the datasource default %q is used", location),
+	}
+}
@@ -0,0 +1,139 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func hasNotice(frame *data.Frame, severity data.NoticeSeverity, text string) bool {
+	if frame.Meta == nil {
+		return false
+	}
+	for _, n := range frame.Meta.Notices {
+		if n.Severity == severity && strings.Contains(n.Text, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCreateDataFramesFallbackNotices(t *testing.T) {
+	ds := newTestDatasource()
+	weather := []WeatherResponse{{
+		City: CityInfo{Name: "Marburg"},
+		List: []ForecastItem{
+			{Dt: 1700000000, Rain: &Rain{ThreeH: 1.5}, Weather: []Weather{{ID: 500}}},
+			{Dt: 1700010800},
+		},
+	}}
+
+	frame, err := ds.createDataFrames(weather, queryModel{Metric: "rain", Format: "3h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasNotice(frame, data.NoticeSeverityWarning, "1 of 2 items have no weather condition") {
+		t.Error("expected a notice for the missing weather condition")
+	}
+	if !hasNotice(frame, data.NoticeSeverityInfo, "1 of 2 items report no rain volume") {
+		t.Error("expected a notice for the missing rain volume")
+	}
+
+	weather[0].List = weather[0].List[:1]
+	frame, err = ds.createDataFrames(weather, queryModel{Metric: "rain", Format: "3h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.Meta != nil && len(frame.Meta.Notices) > 0 {
+		t.Errorf("expected no notices, got %v", frame.Meta.Notices)
+	}
+}
+
+func TestCoverageNotices(t *testing.T) {
+	start := time.Unix(1700000000, 0).UTC()
+	frame := data.NewFrame("weather", data.NewField("time", nil, []time.Time{start, start.Add(3 * time.Hour)}))
+
+	notices := coverageNotices(frame, backend.TimeRange{From: start.Add(-time.Hour), To: start.Add(4 * time.Hour)}, forecastStep, 0)
+	if len(notices) != 0 {
+		t.Errorf("gaps within one step must not be reported, got %v", notices)
+	}
+
+	notices = coverageNotices(frame, backend.TimeRange{From: start.Add(-24 * time.Hour), To: start.Add(24 * time.Hour)}, forecastStep, 0)
+	if len(notices) != 2 {
+		t.Errorf("expected notices for both ends of the time range, got %v", notices)
+	}
+
+	// Frames shifted into local days are compared with the range shifted alike
+	shift := 9 * time.Hour
+	shifted := data.NewFrame("weather", data.NewField("time", nil, []time.Time{start.Add(shift), start.Add(3*time.Hour + shift)}))
+	notices = coverageNotices(shifted, backend.TimeRange{From: start.Add(-time.Hour), To: start.Add(4 * time.Hour)}, forecastStep, shift)
+	if len(notices) != 0 {
+		t.Errorf("expected the shifted frame to cover the time range, got %v", notices)
+	}
+}
+
+func TestPartialResultNotices(t *testing.T) {
+	server, _ := newTestServer(t)
+	ds := newTestDatasourceWithServer(server)
+
+	resp, err := ds.QueryData(context.Background(), queryTypeRequest(
+		backend.DataQuery{RefID: "A", QueryType: QueryTypeCurrent, JSON: []byte(`{"city": "Marburg"}`)},
+		backend.DataQuery{RefID: "B", QueryType: QueryTypeCurrent, JSON: []byte(`{"lat": 100, "lon": 8.77}`)},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Responses["B"].Error == nil {
+		t.Fatal("expected query B to fail")
+	}
+	res := resp.Responses["A"]
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if !hasNotice(res.Frames[0], data.NoticeSeverityWarning, "1 of 2 queries failed") ||
+		!hasNotice(res.Frames[0], data.NoticeSeverityWarning, "query B failed") {
+		t.Errorf("expected a notice about the failed location, got %v", res.Frames[0].Meta.Notices)
+	}
+
+	resp, err = ds.QueryData(context.Background(), queryTypeRequest(
+		backend.DataQuery{RefID: "A", QueryType: QueryTypeCurrent, JSON: []byte(`{"city": "Marburg"}`)},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasNotice(resp.Responses["A"].Frames[0], data.NoticeSeverityWarning, "queries failed") {
+		t.Error("expected no partial result notice when every query succeeds")
+	}
+}
+
+func TestHistoryQueryTruncatesLongRanges(t *testing.T) {
+	server, _ := newTestServer(t)
+	ds := newTestDatasourceWithServer(server)
+	ds.settings.DefaultLocation = "Marburg"
+
+	now := time.Unix(1700000000, 0)
+	resp, err := ds.QueryData(context.Background(), queryTypeRequest(backend.DataQuery{
+		RefID:     "A",
+		QueryType: QueryTypeHistory,
+		JSON:      []byte(`{"metric": "main"}`),
+		TimeRange: backend.TimeRange{From: now.Add(-30 * 24 * time.Hour), To: now},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := resp.Responses["A"]
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if !hasNotice(res.Frames[0], data.NoticeSeverityWarning, "time range was truncated") {
+		t.Error("expected a notice for the truncated time range")
+	}
+	if !hasNotice(res.Frames[0], data.NoticeSeverityInfo, `datasource default "Marburg"`) {
+		t.Error("expected a notice for the default location")
+	}
+}
@@ -0,0 +1,133 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// planCacheTTL is how long a discovered entitlement is trusted. Plans rarely change, but
+// an upgrade should be picked up without restarting Grafana.
+const planCacheTTL = time.Hour
+
+// entitlement records whether an API key may use an endpoint
+type entitlement struct {
+	allowed   bool
+	checkedAt time.Time
+}
+
+// planCache remembers which endpoints each API key is entitled to. OpenWeather answers
+// endpoints outside the plan of a key with 401, like invalid keys, so the plan is
+// discovered from the responses.
+type planCache struct {
+	mu sync.Mutex
+	// entries are keyed by the key ID, see keyID
+	entries map[string]map[Endpoint]entitlement
+	now     func() time.Time
+}
+
+func newPlanCache() *planCache {
+	return &planCache{entries: map[string]map[Endpoint]entitlement{}, now: time.Now}
+}
+
+// lookup returns whether the key may use endpoint. known is false if that was not
+// discovered yet or the entitlement expired.
+func (c *planCache) lookup(keyID string, endpoint Endpoint) (allowed bool, known bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[keyID][endpoint]
+	if !ok || c.now().Sub(e.checkedAt) > planCacheTTL {
+		return false, false
+	}
+	return e.allowed, true
+}
+
+// entitled reports whether the key may use endpoint, or that is not known yet
+func (c *planCache) entitled(keyID string, endpoint Endpoint) bool {
+	allowed, known := c.lookup(keyID, endpoint)
+	return allowed || !known
+}
+
+func (c *planCache) record(keyID string, endpoint Endpoint, allowed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries[keyID] == nil {
+		c.entries[keyID] = map[Endpoint]entitlement{}
+	}
+	c.entries[keyID][endpoint] = entitlement{allowed: allowed, checkedAt: c.now()}
+}
+
+// keyValid reports whether any endpoint accepted the key recently
+func (c *planCache) keyValid(keyID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.entries[keyID] {
+		if e.allowed && c.now().Sub(e.checkedAt) <= planCacheTTL {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyUnauthorized tells a key that is not entitled to a plan endpoint apart from an
+// invalid key. Unless the key was accepted recently, it is verified against the current
+// weather endpoint, which every plan includes. The verification counts against the rate
+// limit of the key and is reported to the key pool like any other call.
+func (d *Datasource) classifyUnauthorized(ctx context.Context, keys *keyPool, k *pooledKey, endpoint Endpoint, err error) error {
+	if !planEndpoints[endpoint] || !errors.Is(err, ErrUnauthorized) {
+		return err
+	}
+
+	if !d.plans.keyValid(k.id) {
+		params := url.Values{"lat": {"0"}, "lon": {"0"}, "appid": {k.key}}
+		requestURL, urlErr := endpointURL(d.settings, EndpointWeather, params)
+		if urlErr != nil {
+			return err
+		}
+		// Over the rate limit the key cannot be verified now, the call fails as rate limited
+		if limitErr := d.limiter.allow(ctx, EndpointWeather, k); limitErr != nil {
+			return limitErr
+		}
+		_, verifyErr := d.do(ctx, EndpointWeather, requestURL)
+		keys.report(k, verifyErr)
+		if verifyErr != nil {
+			return err
+		}
+		d.plans.record(k.id, EndpointWeather, true)
+	}
+
+	d.logger.FromContext(ctx).Info("Endpoint is not included in the plan of the API key", "endpoint", endpoint, "keyId", k.id)
+	d.plans.record(k.id, endpoint, false)
+	statusCode := 0
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		statusCode = upstreamErr.StatusCode
+	}
+	return newNotInPlanError(endpoint, statusCode)
+}
+
+// fetch calls endpoint like fetchCached. If the endpoint is not included in the plan of
+// the API keys, the query is routed to an equivalent endpoint and a notice tells the user.
+func (d *Datasource) fetch(ctx context.Context, endpoint Endpoint, params url.Values, out interface{}) error {
+	err := d.fetchCached(ctx, endpoint, params, out)
+	fallback, ok := endpointFallbacks[endpoint]
+	if !ok || !errors.Is(err, ErrNotInPlan) {
+		return err
+	}
+
+	if fallbackErr := d.fetchCached(ctx, fallback, params, out); fallbackErr != nil {
+		d.logger.FromContext(ctx).Warn("Fallback endpoint failed", "endpoint", fallback, "error", fallbackErr)
+		return err
+	}
+	queryTraceFrom(ctx).addNotice(data.Notice{
+		Severity: data.NoticeSeverityInfo,
+		Text: fmt.Sprintf("The %s endpoint is not included in your OpenWeather plan, the data was loaded from the %s endpoint instead",
+			endpoint, fallback),
+	})
+	return nil
+}
@@ -0,0 +1,143 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/models"
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/plugin/instrumentation"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func newPlanDatasource(server *httptest.Server, keys ...string) *Datasource {
+	return newTestDatasourceWithServer(server, func(s *models.PluginSettings) {
+		s.Secrets.ApiKeys = keys
+	})
+}
+
+func TestNotInPlanError(t *testing.T) {
+	server, requests := newTestServer(t, withPlans(map[string][]string{"free-key": {"/data/2.5/history/city"}}))
+	ds := newPlanDatasource(server, "free-key")
+
+	now := time.Unix(1700000000, 0)
+	query := backend.DataQuery{RefID: "A", QueryType: QueryTypeHistory, JSON: []byte(`{"lat": 50.81, "lon": 8.77, "metric": "main"}`),
+		TimeRange: backend.TimeRange{From: now.Add(-time.Hour), To: now}}
+	for i := 0; i < 2; i++ {
+		resp, err := ds.QueryData(context.Background(), queryTypeRequest(query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := resp.Responses["A"]
+		if !errors.Is(res.Error, ErrNotInPlan) || res.Status != backend.StatusForbidden ||
+			!strings.Contains(res.Error.Error(), "not included in your OpenWeather plan") {
+			t.Fatalf("expected a not in plan error, got %v %v", res.Status, res.Error)
+		}
+		if got := responseErrorType(res); got != instrumentation.ErrorTypeNotInPlan {
+			t.Errorf("expected error type %s, got %s", instrumentation.ErrorTypeNotInPlan, got)
+		}
+	}
+
+	// The plan is discovered once, the key stays in rotation
+	if n := requests.count("/data/2.5/history/city"); n != 1 {
+		t.Errorf("expected the history endpoint to be called once, got %d", n)
+	}
+	if n := requests.count("/data/2.5/weather"); n != 1 {
+		t.Errorf("expected the key to be verified once, got %d", n)
+	}
+	if !ds.keys.Load().keys[0].coolUntil.IsZero() {
+		t.Error("expected the key not to cool down")
+	}
+}
+
+func TestInvalidKeyOnPlanEndpoint(t *testing.T) {
+	server, _ := newTestServer(t, withPlans(map[string][]string{}))
+	ds := newPlanDatasource(server, "invalid-key")
+
+	var history HistoryResponse
+	err := ds.fetch(context.Background(), EndpointHistory, nil, &history)
+	if !errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrNotInPlan) {
+		t.Errorf("expected an invalid key error, got %v", err)
+	}
+}
+
+func TestAlertsOutsideThePlanFail(t *testing.T) {
+	server, requests := newTestServer(t, withPlans(map[string][]string{"free-key": {"/data/3.0/onecall"}}))
+	ds := newPlanDatasource(server, "free-key")
+
+	for i := 0; i < 2; i++ {
+		resp, err := ds.QueryData(context.Background(), queryTypeRequest(
+			backend.DataQuery{RefID: "A", QueryType: QueryTypeAlerts, JSON: []byte(`{"lat": 50.81, "lon": 8.77}`)},
+		))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// No alerts would read as "all clear" to an alert rule, the query has to fail
+		if res := resp.Responses["A"]; !errors.Is(res.Error, ErrNotInPlan) || len(res.Frames) != 0 {
+			t.Fatalf("expected a not in plan error without frames, got %v %v", res.Error, res.Frames)
+		}
+	}
+
+	if n := requests.count("/data/3.0/onecall"); n != 1 {
+		t.Errorf("expected One Call 3.0 to be skipped once the plan is known, got %d calls", n)
+	}
+	if n := requests.count("/data/2.5/weather"); n != 1 {
+		t.Errorf("expected only the key verification on the weather endpoint, got %d calls", n)
+	}
+}
+
+func TestKeyVerificationCountsAgainstRateLimit(t *testing.T) {
+	server, requests := newTestServer(t, withPlans(map[string][]string{"free-key": {"/data/2.5/history/city"}}))
+	ds := newTestDatasourceWithServer(server, func(s *models.PluginSettings) {
+		s.Secrets.ApiKey = "free-key"
+		s.RateLimitPerMinute = 1
+	})
+
+	var history HistoryResponse
+	err := ds.fetch(context.Background(), EndpointHistory, nil, &history)
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected the verification to be held back by the rate limit, got %v", err)
+	}
+	if n := requests.count("/data/2.5/weather"); n != 0 {
+		t.Errorf("expected no verification call over the rate limit, got %d", n)
+	}
+	if _, known := ds.plans.lookup(keyID("free-key"), EndpointHistory); known {
+		t.Error("expected the plan to stay unknown until the key is verified")
+	}
+}
+
+func TestKeyWithPlanIsPreferred(t *testing.T) {
+	server, _ := newTestServer(t, withPlans(map[string][]string{
+		"free-key": {"/data/2.5/history/city"},
+		"paid-key": nil,
+	}))
+	ds := newPlanDatasource(server, "free-key", "paid-key")
+
+	for i := 0; i < 3; i++ {
+		var history HistoryResponse
+		if err := ds.fetch(context.Background(), EndpointHistory, nil, &history); err != nil {
+			t.Fatalf("expected the paid key to be used, got %v", err)
+		}
+	}
+	if allowed, known := ds.plans.lookup(keyID("free-key"), EndpointHistory); !known || allowed {
+		t.Error("expected the plan of the free key to be known")
+	}
+}
+
+func TestPlanCacheExpires(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	plans := newPlanCache()
+	plans.now = func() time.Time { return now }
+
+	plans.record("key", EndpointHistory, false)
+	if plans.entitled("key", EndpointHistory) || plans.keyValid("key") {
+		t.Error("expected the key not to be entitled")
+	}
+	now = now.Add(planCacheTTL + time.Second)
+	if _, known := plans.lookup("key", EndpointHistory); known {
+		t.Error("expected the entitlement to expire")
+	}
+}
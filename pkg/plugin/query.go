@@ -0,0 +1,180 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// queryModelVersion is the version of the query schema understood by the backend.
+//
+// Version history:
+//   - 0: queries stored by the frontend with mainParameter/subParameter, optionally with
+//     metric/format copied in by applyTemplateVariables, a dotted "main.temp" metric, or
+//     the city only in queryText
+//   - 1: metric and format are always set, mainParameter/subParameter mirror them
+const queryModelVersion = 1
+
+// metricFormats is the catalog of metrics and the formats they support. The first
+// format of each metric is its default.
+var metricFormats = map[string][]string{
+	"main":   {"temp", "feels_like", "temp_min", "temp_max", "pressure", "sea_level", "grnd_level", "humidity"},
+	"wind":   {"speed", "deg", "gust"},
+	"clouds": {"all"},
+	"rain":   {"3h"},
+}
+
+// errUnknownMetric is returned when frames are requested for a metric or format that is
+// not in the catalog. Queries are validated before, so this indicates a bug.
+var errUnknownMetric = errors.New("unknown metric")
+
+// metricNames returns the names of all metrics in a stable order
+func metricNames() []string {
+	names := make([]string, 0, len(metricFormats))
+	for name := range metricFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseQuery decodes the query JSON and migrates it to queryModelVersion
+func parseQuery(raw json.RawMessage) (queryModel, error) {
+	var qm queryModel
+	if err := json.Unmarshal(raw, &qm); err != nil {
+		return qm, fmt.Errorf("json unmarshal: %w", err)
+	}
+	if err := qm.migrate(); err != nil {
+		return qm, err
+	}
+	return qm, nil
+}
+
+// migrate brings every historical query shape to the current version. Unknown
+// metrics are kept as they are and rejected by validate. Queries of a newer version
+// and shapes whose fields contradict each other cannot be migrated.
+func (qm *queryModel) migrate() error {
+	if qm.SchemaVersion > queryModelVersion {
+		return fmt.Errorf("query schema version %d is newer than the supported version %d, update the plugin", qm.SchemaVersion, queryModelVersion)
+	}
+	if qm.Metric == "" {
+		qm.Metric = qm.MainParameter
+	}
+	if qm.Format == "" {
+		qm.Format = qm.SubParameter
+	}
+
+	// A dotted metric like "main.temp" carries its format
+	if metric, format, ok := strings.Cut(qm.Metric, "."); ok {
+		if qm.Format != "" && qm.Format != format {
+			return fmt.Errorf("metric %q contradicts format %q", qm.Metric, qm.Format)
+		}
+		qm.Metric = metric
+		qm.Format = format
+	}
+
+	if qm.City == "" {
+		qm.City = qm.QueryText
+	}
+
+	if formats, ok := metricFormats[qm.Metric]; ok && qm.Format == "" {
+		qm.Format = formats[0]
+	}
+
+	qm.MainParameter = qm.Metric
+	qm.SubParameter = qm.Format
+	qm.SchemaVersion = queryModelVersion
+	return nil
+}
+
+// check adds the problems with the metric, format and units to errs
+func (qm queryModel) check(errs *queryErrors) {
+	validateMetric(errs, qm.Metric, qm.Format)
+	validateUnits(errs, qm.Units)
+}
+
+// validate checks a forecast query after migration and defaults were applied
+func (qm queryModel) validate() error {
+	var errs queryErrors
+	if qm.City == "" {
+		errs.add("city", "is required")
+	}
+	qm.check(&errs)
+	return errs.err()
+}
+
+// Make sure QueryConversionHandler implements the SDK interface
+var _ backend.QueryConversionHandler = (*QueryConversionHandler)(nil)
+
+// QueryConversionHandler converts stored queries of any historical shape to the current
+// query schema, so alert rules and recorded queries use the same model as panels.
+type QueryConversionHandler struct{}
+
+// NewQueryConversionHandler creates the query conversion handler passed to datasource.Manage
+func NewQueryConversionHandler() *QueryConversionHandler {
+	return &QueryConversionHandler{}
+}
+
+// ConvertQueryDataRequest migrates every query of the request. Fields the backend does
+// not know about are preserved.
+func (h *QueryConversionHandler) ConvertQueryDataRequest(_ context.Context, req *backend.QueryDataRequest) (*backend.QueryConversionResponse, error) {
+	queries := make([]any, 0, len(req.Queries))
+	for _, q := range req.Queries {
+		converted, err := convertQuery(q)
+		if err != nil {
+			return &backend.QueryConversionResponse{
+				Result: &backend.StatusResult{
+					Status:  "Failure",
+					Message: fmt.Sprintf("query %s: %v", q.RefID, err),
+					Reason:  "BadRequest",
+					Code:    http.StatusBadRequest,
+				},
+			}, nil
+		}
+		queries = append(queries, converted)
+	}
+	return &backend.QueryConversionResponse{Queries: queries}, nil
+}
+
+// convertQuery returns the migrated query JSON as a map. Only forecast queries have
+// historical shapes, queries of other types are passed through.
+func convertQuery(q backend.DataQuery) (map[string]any, error) {
+	converted := map[string]any{}
+	if err := json.Unmarshal(q.JSON, &converted); err != nil {
+		return nil, fmt.Errorf("json unmarshal: %w", err)
+	}
+	converted["refId"] = q.RefID
+	if q.QueryType != "" {
+		converted["queryType"] = q.QueryType
+	}
+	if q.QueryType != "" && q.QueryType != QueryTypeForecast {
+		return converted, nil
+	}
+
+	qm, err := parseQuery(q.JSON)
+	if err != nil {
+		return nil, err
+	}
+
+	// The location may come from the datasource defaults, so only the catalog is checked
+	var errs queryErrors
+	qm.check(&errs)
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+
+	migrated, err := json.Marshal(qm)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(migrated, &converted); err != nil {
+		return nil, err
+	}
+	return converted, nil
+}
@@ -0,0 +1,98 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// airQualityQuery requests the air quality index and pollutant concentrations
+type airQualityQuery struct {
+	locationQuery
+}
+
+func (q airQualityQuery) validate() error {
+	var errs queryErrors
+	validateLocation(&errs, q.locationQuery)
+	return errs.err()
+}
+
+// handleAirQualityQuery returns the air quality index and its components
+func (d *Datasource) handleAirQualityQuery(ctx context.Context, _ backend.PluginContext, query backend.DataQuery) backend.DataResponse {
+	var q airQualityQuery
+	if err := json.Unmarshal(query.JSON, &q); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("json unmarshal: %v", err))
+	}
+	var notices []data.Notice
+	q.locationQuery, notices = d.queryLocation(q.locationQuery)
+	d.tracer.SetQueryAttributes(ctx, q.attributes())
+	if err := q.validate(); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+
+	location, err := d.resolveLocation(ctx, q.locationQuery)
+	if err != nil {
+		return errorResponse(err, "Failed to resolve location")
+	}
+
+	var pollution AirPollutionResponse
+	if err := d.fetch(ctx, EndpointAirPollution, location.params(), &pollution); err != nil {
+		return errorResponse(err, "Failed to fetch air quality")
+	}
+
+	frame := airQualityFrame(location.Name, pollution)
+	frame.AppendNotices(notices...)
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
+
+// airQualityFrame converts the air pollution items into a frame with one field per component
+func airQualityFrame(name string, pollution AirPollutionResponse) *data.Frame {
+	var components []string
+	for _, item := range pollution.List {
+		for component := range item.Components {
+			components = appendUnique(components, component)
+		}
+	}
+	sort.Strings(components)
+
+	times := make([]time.Time, 0, len(pollution.List))
+	aqi := make([]int64, 0, len(pollution.List))
+	values := make([][]float64, len(components))
+	for _, item := range pollution.List {
+		times = append(times, time.Unix(item.Dt, 0).UTC())
+		aqi = append(aqi, int64(item.Main.AQI))
+		for i, component := range components {
+			values[i] = append(values[i], item.Components[component])
+		}
+	}
+
+	frame := data.NewFrame(name,
+		data.NewField("time", nil, times),
+		data.NewField("aqi", nil, aqi),
+	)
+	for i, component := range components {
+		frame.Fields = append(frame.Fields, data.NewField(component, nil, values[i]))
+	}
+	frame.Meta = &data.FrameMeta{
+		Custom: map[string]interface{}{
+			"city": name,
+			"lat":  pollution.Coord.Lat,
+			"lon":  pollution.Coord.Lon,
+		},
+	}
+	return frame
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
@@ -0,0 +1,82 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// alertsQuery requests the active government weather alerts of a location
+type alertsQuery struct {
+	locationQuery
+}
+
+func (q alertsQuery) validate() error {
+	var errs queryErrors
+	validateLocation(&errs, q.locationQuery)
+	return errs.err()
+}
+
+// handleAlertsQuery returns the weather alerts reported by the One Call API
+func (d *Datasource) handleAlertsQuery(ctx context.Context, _ backend.PluginContext, query backend.DataQuery) backend.DataResponse {
+	var q alertsQuery
+	if err := json.Unmarshal(query.JSON, &q); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("json unmarshal: %v", err))
+	}
+	var notices []data.Notice
+	q.locationQuery, notices = d.queryLocation(q.locationQuery)
+	d.tracer.SetQueryAttributes(ctx, q.attributes())
+	if err := q.validate(); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+
+	location, err := d.resolveLocation(ctx, q.locationQuery)
+	if err != nil {
+		return errorResponse(err, "Failed to resolve location")
+	}
+
+	params := location.params()
+	params.Set("exclude", "current,minutely,hourly,daily")
+	params.Set("lang", d.settings.DefaultLanguage)
+
+	var oneCall OneCallResponse
+	if err := d.fetch(ctx, EndpointOneCall, params, &oneCall); err != nil {
+		return errorResponse(err, "Failed to fetch weather alerts")
+	}
+
+	frame := alertsFrame(location.Name, oneCall.Alerts)
+	frame.AppendNotices(notices...)
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
+
+// alertsFrame converts the weather alerts into a table frame
+func alertsFrame(name string, alerts []WeatherAlert) *data.Frame {
+	var starts, ends []time.Time
+	var events, senders, descriptions, tags []string
+	for _, a := range alerts {
+		starts = append(starts, time.Unix(a.Start, 0).UTC())
+		ends = append(ends, time.Unix(a.End, 0).UTC())
+		events = append(events, a.Event)
+		senders = append(senders, a.SenderName)
+		descriptions = append(descriptions, a.Description)
+		tags = append(tags, strings.Join(a.Tags, ", "))
+	}
+
+	frame := data.NewFrame(name,
+		data.NewField("start", nil, starts),
+		data.NewField("end", nil, ends),
+		data.NewField("event", nil, events),
+		data.NewField("sender", nil, senders),
+		data.NewField("description", nil, descriptions),
+		data.NewField("tags", nil, tags),
+	)
+	frame.Meta = &data.FrameMeta{
+		Custom: map[string]interface{}{"city": name},
+	}
+	return frame
+}
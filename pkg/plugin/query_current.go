@@ -0,0 +1,88 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// currentQuery requests the current weather of a location
+type currentQuery struct {
+	locationQuery
+	Units string `json:"units"`
+}
+
+func (q currentQuery) validate() error {
+	var errs queryErrors
+	validateLocation(&errs, q.locationQuery)
+	validateUnits(&errs, q.Units)
+	return errs.err()
+}
+
+// handleCurrentQuery returns the current weather as a single row frame
+func (d *Datasource) handleCurrentQuery(ctx context.Context, _ backend.PluginContext, query backend.DataQuery) backend.DataResponse {
+	var q currentQuery
+	if err := json.Unmarshal(query.JSON, &q); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("json unmarshal: %v", err))
+	}
+	var notices []data.Notice
+	q.locationQuery, notices = d.queryLocation(q.locationQuery)
+	attrs := q.attributes()
+	attrs.Units = d.queryUnits(q.Units)
+	d.tracer.SetQueryAttributes(ctx, attrs)
+	if err := q.validate(); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+
+	params := url.Values{}
+	if q.Lat != nil && q.Lon != nil {
+		params = resolvedLocation{Lat: *q.Lat, Lon: *q.Lon}.params()
+	} else {
+		params.Set("q", q.City)
+	}
+	params.Set("units", d.queryUnits(q.Units))
+	params.Set("lang", d.settings.DefaultLanguage)
+
+	var current CurrentWeatherResponse
+	if err := d.fetch(ctx, EndpointWeather, params, &current); err != nil {
+		return errorResponse(err, "Failed to fetch current weather")
+	}
+
+	queryTraceFrom(ctx).setLocation(resolvedLocation{Name: current.Name, Lat: current.Coord.Lat, Lon: current.Coord.Lon})
+
+	frame := currentWeatherFrame(current)
+	frame.AppendNotices(notices...)
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
+
+// currentWeatherFrame converts the current weather into a wide frame with one row
+func currentWeatherFrame(current CurrentWeatherResponse) *data.Frame {
+	description := ""
+	if len(current.Weather) > 0 {
+		description = current.Weather[0].Description
+	}
+
+	frame := data.NewFrame(current.Name,
+		data.NewField("time", nil, []time.Time{time.Unix(current.Dt, 0).UTC()}),
+		data.NewField("temp", nil, []float64{current.Main.Temp}),
+		data.NewField("feels_like", nil, []float64{current.Main.FeelsLike}),
+		data.NewField("pressure", nil, []float64{current.Main.Pressure}),
+		data.NewField("humidity", nil, []float64{current.Main.Humidity}),
+		data.NewField("wind_speed", nil, []float64{current.Wind.Speed}),
+		data.NewField("wind_deg", nil, []float64{current.Wind.Deg}),
+		data.NewField("clouds", nil, []float64{current.Clouds.All}),
+		data.NewField("description", nil, []string{description}),
+	)
+	frame.Meta = &data.FrameMeta{
+		Custom: map[string]interface{}{
+			"city":     current.Name,
+			"timezone": formatUTCOffset(current.Timezone),
+		},
+	}
+	return frame
+}
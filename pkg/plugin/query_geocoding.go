@@ -0,0 +1,71 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/plugin/instrumentation"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// maxGeocodingLimit is the largest number of results the geocoding API returns
+const maxGeocodingLimit = 5
+
+// geocodingQuery looks up the coordinates of a city name
+type geocodingQuery struct {
+	City  string `json:"city"`
+	Limit int    `json:"limit"`
+}
+
+func (q geocodingQuery) validate() error {
+	var errs queryErrors
+	if q.City == "" {
+		errs.add("city", "is required")
+	}
+	if q.Limit < 1 || q.Limit > maxGeocodingLimit {
+		errs.add("limit", "must be between 1 and %d, got %d", maxGeocodingLimit, q.Limit)
+	}
+	return errs.err()
+}
+
+// handleGeocodingQuery returns the matching locations as a table
+func (d *Datasource) handleGeocodingQuery(ctx context.Context, _ backend.PluginContext, query backend.DataQuery) backend.DataResponse {
+	q := geocodingQuery{Limit: 1}
+	if err := json.Unmarshal(query.JSON, &q); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("json unmarshal: %v", err))
+	}
+	d.tracer.SetQueryAttributes(ctx, instrumentation.QueryAttributes{City: q.City})
+	if err := q.validate(); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+
+	locations, err := d.geocode(ctx, q.City, q.Limit)
+	if err != nil {
+		return errorResponse(err, "Failed to geocode location")
+	}
+
+	return backend.DataResponse{Frames: data.Frames{geocodingFrame(locations)}}
+}
+
+// geocodingFrame converts the locations into a table frame
+func geocodingFrame(locations []GeoLocation) *data.Frame {
+	var names, countries, states []string
+	var lats, lons []float64
+	for _, l := range locations {
+		names = append(names, l.Name)
+		countries = append(countries, l.Country)
+		states = append(states, l.State)
+		lats = append(lats, l.Lat)
+		lons = append(lons, l.Lon)
+	}
+
+	return data.NewFrame("locations",
+		data.NewField("name", nil, names),
+		data.NewField("country", nil, countries),
+		data.NewField("state", nil, states),
+		data.NewField("lat", nil, lats),
+		data.NewField("lon", nil, lons),
+	)
+}
@@ -0,0 +1,96 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// maxHistoryRangeDays is the longest time range a single history request may cover,
+// longer ranges are truncated
+const maxHistoryRangeDays = 7
+
+// historyQuery requests hourly historical weather for the time range of the query
+type historyQuery struct {
+	locationQuery
+	Metric string `json:"metric"`
+	Format string `json:"format"`
+	Units  string `json:"units"`
+}
+
+func (q historyQuery) validate(timeRange backend.TimeRange) error {
+	var errs queryErrors
+	validateLocation(&errs, q.locationQuery)
+	validateMetric(&errs, q.Metric, q.Format)
+	validateUnits(&errs, q.Units)
+	if timeRange.From.IsZero() || !timeRange.To.After(timeRange.From) {
+		errs.add("timeRange", "must not be empty")
+	}
+	return errs.err()
+}
+
+// handleHistoryQuery returns historical weather using the same frame layout as forecasts
+func (d *Datasource) handleHistoryQuery(ctx context.Context, _ backend.PluginContext, query backend.DataQuery) backend.DataResponse {
+	var q historyQuery
+	if err := json.Unmarshal(query.JSON, &q); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("json unmarshal: %v", err))
+	}
+	var notices []data.Notice
+	q.locationQuery, notices = d.queryLocation(q.locationQuery)
+
+	// History queries share the metric catalog of forecast queries
+	qm := queryModel{Metric: q.Metric, Format: q.Format, City: q.City}
+	if err := qm.migrate(); err != nil {
+		return backend.ErrDataResponseWithSource(backend.StatusBadRequest, backend.ErrorSourceDownstream, err.Error())
+	}
+	q.Metric, q.Format = qm.Metric, qm.Format
+
+	attrs := q.attributes()
+	attrs.Metric, attrs.Format, attrs.Units = q.Metric, q.Format, d.queryUnits(q.Units)
+	d.tracer.SetQueryAttributes(ctx, attrs)
+	if err := q.validate(query.TimeRange); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	qm.Units = d.queryUnits(q.Units)
+
+	// Longer ranges are cut to the most recent days the API can return at once
+	timeRange := query.TimeRange
+	if maxRange := maxHistoryRangeDays * 24 * time.Hour; timeRange.Duration() > maxRange {
+		timeRange.From = timeRange.To.Add(-maxRange)
+		notices = append(notices, truncatedRangeNotice(query.TimeRange, timeRange))
+	}
+
+	location, err := d.resolveLocation(ctx, q.locationQuery)
+	if err != nil {
+		return errorResponse(err, "Failed to resolve location")
+	}
+
+	params := location.params()
+	params.Set("type", "hour")
+	params.Set("start", strconv.FormatInt(timeRange.From.Unix(), 10))
+	params.Set("end", strconv.FormatInt(timeRange.To.Unix(), 10))
+	params.Set("units", qm.Units)
+
+	var history HistoryResponse
+	if err := d.fetch(ctx, EndpointHistory, params, &history); err != nil {
+		return errorResponse(err, "Failed to fetch historical weather")
+	}
+
+	weather := []WeatherResponse{{
+		List: history.List,
+		City: CityInfo{Name: location.Name, Coord: Coord{Lat: location.Lat, Lon: location.Lon}},
+	}}
+	frame, err := d.createDataFrames(weather, qm)
+	if err != nil {
+		return errorResponse(err, "Failed to create frames")
+	}
+
+	frame.AppendNotices(notices...)
+	frame.AppendNotices(coverageNotices(frame, timeRange, time.Hour, 0)...)
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
@@ -0,0 +1,154 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestParseQueryMigratesHistoricalShapes(t *testing.T) {
+	for name, raw := range map[string]string{
+		"frontend":       `{"city": "Marburg", "mainParameter": "wind", "subParameter": "gust"}`,
+		"template":       `{"city": "Marburg", "mainParameter": "wind", "subParameter": "gust", "metric": "wind", "format": "gust"}`,
+		"backend":        `{"city": "Marburg", "metric": "wind", "format": "gust"}`,
+		"dotted":         `{"city": "Marburg", "metric": "wind.gust"}`,
+		"queryText city": `{"queryText": "Marburg", "mainParameter": "wind", "subParameter": "gust"}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			qm, err := parseQuery([]byte(raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if qm.City != "Marburg" || qm.Metric != "wind" || qm.Format != "gust" {
+				t.Errorf("unexpected query model %+v", qm)
+			}
+			if qm.MainParameter != "wind" || qm.SubParameter != "gust" || qm.SchemaVersion != queryModelVersion {
+				t.Errorf("query was not migrated to the current version: %+v", qm)
+			}
+		})
+	}
+
+	qm, err := parseQuery([]byte(`{"city": "Marburg", "mainParameter": "clouds"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if qm.Format != "all" {
+		t.Errorf("expected the default format of clouds, got %q", qm.Format)
+	}
+}
+
+func TestParseQueryRejectsInvalidShapes(t *testing.T) {
+	for name, raw := range map[string]string{
+		"newer version":         `{"city": "Marburg", "metric": "wind", "format": "gust", "schemaVersion": 99}`,
+		"contradicting formats": `{"city": "Marburg", "metric": "wind.gust", "format": "speed"}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseQuery([]byte(raw)); err == nil {
+				t.Error("expected a migration error")
+			}
+		})
+	}
+}
+
+func TestInvalidLegacyHistoryQuery(t *testing.T) {
+	server, requests := newTestServer(t)
+	ds := newTestDatasourceWithServer(server)
+
+	now := time.Unix(1700000000, 0)
+	resp, err := ds.QueryData(context.Background(), queryTypeRequest(
+		backend.DataQuery{RefID: "A", QueryType: QueryTypeHistory, JSON: []byte(`{"city": "Marburg", "metric": "main.temp", "format": "humidity"}`),
+			TimeRange: backend.TimeRange{From: now.Add(-time.Hour), To: now}},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := resp.Responses["A"]
+	if res.Error == nil || res.Status != backend.StatusBadRequest || res.ErrorSource != backend.ErrorSourceDownstream {
+		t.Errorf("expected a downstream bad request, got %v %v %v", res.Status, res.ErrorSource, res.Error)
+	}
+	if paths := requests.paths(); len(paths) != 0 {
+		t.Errorf("expected no upstream calls, got %v", paths)
+	}
+}
+
+func TestQueryModelValidate(t *testing.T) {
+	for raw, fields := range map[string][]string{
+		`{"city": "Marburg"}`:                                        {"metric"},
+		`{"city": "Marburg", "metric": "snow"}`:                      {"metric"},
+		`{"city": "Marburg", "metric": "main", "format": "foo"}`:     {"format"},
+		`{"city": "Marburg", "metric": "rain", "format": "1h"}`:      {"format"},
+		`{"metric": "main.foo", "units": "kelvin"}`:                  {"city", "format", "units"},
+		`{"city": "Marburg", "metric": "wind", "units": "imperial"}`: nil,
+	} {
+		qm, err := parseQuery([]byte(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = qm.validate()
+		if len(fields) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", raw, err)
+			}
+			continue
+		}
+
+		var errs queryErrors
+		if !errors.As(err, &errs) {
+			t.Fatalf("%s: expected queryErrors, got %v", raw, err)
+		}
+		if len(errs) != len(fields) {
+			t.Errorf("%s: expected %d problems, got %v", raw, len(fields), err)
+		}
+		for _, field := range fields {
+			if !errs.has(field) {
+				t.Errorf("%s: expected a problem with %s, got %v", raw, field, err)
+			}
+		}
+	}
+}
+
+func TestQueryModelValidateListsValidChoices(t *testing.T) {
+	qm, _ := parseQuery([]byte(`{"city": "Marburg", "metric": "wind", "format": "direction"}`))
+	err := qm.validate()
+	if err == nil || !strings.Contains(err.Error(), "speed, deg, gust") {
+		t.Errorf("expected the valid formats in the error, got %v", err)
+	}
+}
+
+func TestConvertQueryDataRequest(t *testing.T) {
+	h := NewQueryConversionHandler()
+	resp, err := h.ConvertQueryDataRequest(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{
+			{RefID: "A", JSON: []byte(`{"city": "Marburg", "mainParameter": "main", "subParameter": "humidity", "hide": false}`)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Result != nil {
+		t.Fatalf("conversion failed: %s", resp.Result.Message)
+	}
+
+	converted := resp.Queries[0].(map[string]any)
+	if converted["metric"] != "main" || converted["format"] != "humidity" || converted["refId"] != "A" {
+		t.Errorf("unexpected converted query %v", converted)
+	}
+	if _, ok := converted["hide"]; !ok {
+		t.Error("unknown fields must be preserved")
+	}
+
+	resp, err = h.ConvertQueryDataRequest(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{{RefID: "B", JSON: []byte(`{"metric": "snow"}`)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Result == nil || resp.Result.Code != 400 {
+		t.Error("unknown metrics must be rejected")
+	}
+}
@@ -0,0 +1,91 @@
+package plugin
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/models"
+)
+
+// queryError describes a problem with a single query field
+type queryError struct {
+	Field   string
+	Message string
+}
+
+// queryErrors collects every problem found in a query, so they can be reported together
+type queryErrors []queryError
+
+func (e queryErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Field+": "+err.Message)
+	}
+	return "invalid query: " + strings.Join(msgs, "; ")
+}
+
+func (e *queryErrors) add(field string, format string, args ...interface{}) {
+	*e = append(*e, queryError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// err returns the collected problems, or nil if there are none
+func (e queryErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// has reports whether a problem was found for field
+func (e queryErrors) has(field string) bool {
+	for _, err := range e {
+		if err.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// validateMetric checks the metric and format against the catalog
+func validateMetric(errs *queryErrors, metric string, format string) {
+	formats, ok := metricFormats[metric]
+	if !ok {
+		if metric == "" {
+			errs.add("metric", "is required, valid metrics are %s", strings.Join(metricNames(), ", "))
+		} else {
+			errs.add("metric", "unknown metric %q, valid metrics are %s", metric, strings.Join(metricNames(), ", "))
+		}
+		return
+	}
+	if !slices.Contains(formats, format) {
+		errs.add("format", "unknown format %q for metric %q, valid formats are %s", format, metric, strings.Join(formats, ", "))
+	}
+}
+
+// validateUnits checks the units of a query. Empty units use the datasource default.
+func validateUnits(errs *queryErrors, units string) {
+	if units != "" && !slices.Contains(models.ValidUnits, units) {
+		errs.add("units", "unknown units %q, valid units are %s", units, strings.Join(models.ValidUnits, ", "))
+	}
+}
+
+// validateLocation checks that the location is complete and the coordinates are in range
+func validateLocation(errs *queryErrors, l locationQuery) {
+	if l.Lat == nil && l.Lon == nil {
+		if l.City == "" {
+			errs.add("city", "city or lat/lon is required")
+		}
+		return
+	}
+	if l.Lat == nil {
+		errs.add("lat", "must be set together with lon")
+	} else if *l.Lat < -90 || *l.Lat > 90 {
+		errs.add("lat", "must be between -90 and 90, got %v", *l.Lat)
+	}
+	if l.Lon == nil {
+		errs.add("lon", "must be set together with lat")
+	} else if *l.Lon < -180 || *l.Lon > 180 {
+		errs.add("lon", "must be between -180 and 180, got %v", *l.Lon)
+	}
+}
@@ -0,0 +1,152 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/plugin/instrumentation"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Query types routed by backend.DataQuery.QueryType. Queries without a query type are
+// forecast queries, since the frontend did not send one before.
+const (
+	QueryTypeForecast   = "forecast"
+	QueryTypeCurrent    = "current"
+	QueryTypeHistory    = "history"
+	QueryTypeAirQuality = "air_quality"
+	QueryTypeGeocoding  = "geocoding"
+	QueryTypeAlerts     = "alerts"
+)
+
+// newQueryTypeMux registers a handler for every query type
+func (d *Datasource) newQueryTypeMux() *datasource.QueryTypeMux {
+	handlers := map[string]queryHandler{
+		QueryTypeForecast:   d.handleForecastQuery,
+		QueryTypeCurrent:    d.handleCurrentQuery,
+		QueryTypeHistory:    d.handleHistoryQuery,
+		QueryTypeAirQuality: d.handleAirQualityQuery,
+		QueryTypeGeocoding:  d.handleGeocodingQuery,
+		QueryTypeAlerts:     d.handleAlertsQuery,
+	}
+
+	mux := datasource.NewQueryTypeMux()
+	for queryType, handler := range handlers {
+		mux.Handle(queryType, d.handleQueries(queryType, handler))
+	}
+	mux.Handle("", d.handleQueries("", d.handleUntypedQuery))
+	return mux
+}
+
+// queryTypes returns all registered query types in a stable order
+func queryTypes() []string {
+	types := []string{
+		QueryTypeForecast,
+		QueryTypeCurrent,
+		QueryTypeHistory,
+		QueryTypeAirQuality,
+		QueryTypeGeocoding,
+		QueryTypeAlerts,
+	}
+	sort.Strings(types)
+	return types
+}
+
+// handleUntypedQuery treats queries without a query type as forecast queries and
+// rejects query types that no handler is registered for
+func (d *Datasource) handleUntypedQuery(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery) backend.DataResponse {
+	if query.QueryType == "" {
+		return d.handleForecastQuery(ctx, pCtx, query)
+	}
+	return backend.ErrDataResponse(backend.StatusBadRequest,
+		fmt.Sprintf("unknown query type %q, valid query types are %s", query.QueryType, strings.Join(queryTypes(), ", ")))
+}
+
+// locationQuery is embedded by every query type that needs a location. A query
+// either names a city or sets both coordinates.
+type locationQuery struct {
+	City string   `json:"city"`
+	Lat  *float64 `json:"lat,omitempty"`
+	Lon  *float64 `json:"lon,omitempty"`
+}
+
+// attributes describes the location on the span of the query
+func (l locationQuery) attributes() instrumentation.QueryAttributes {
+	return instrumentation.QueryAttributes{City: l.City, Lat: l.Lat, Lon: l.Lon}
+}
+
+// resolvedLocation is a location with coordinates
+type resolvedLocation struct {
+	Name string
+	Lat  float64
+	Lon  float64
+}
+
+// params returns the coordinates as query parameters
+func (l resolvedLocation) params() url.Values {
+	return url.Values{
+		"lat": {strconv.FormatFloat(l.Lat, 'f', -1, 64)},
+		"lon": {strconv.FormatFloat(l.Lon, 'f', -1, 64)},
+	}
+}
+
+// resolveLocation returns the coordinates of the location, using the geocoding API for cities
+func (d *Datasource) resolveLocation(ctx context.Context, l locationQuery) (resolvedLocation, error) {
+	if l.Lat != nil && l.Lon != nil {
+		location := resolvedLocation{Name: l.City, Lat: *l.Lat, Lon: *l.Lon}
+		queryTraceFrom(ctx).setLocation(location)
+		return location, nil
+	}
+
+	locations, err := d.geocode(ctx, l.City, 1)
+	if err != nil {
+		return resolvedLocation{}, err
+	}
+	if len(locations) == 0 {
+		return resolvedLocation{}, &UpstreamError{
+			Kind:     ErrNotFound,
+			Endpoint: EndpointGeo,
+			Message:  "location not found: " + l.City,
+		}
+	}
+	location := resolvedLocation{Name: locations[0].Name, Lat: locations[0].Lat, Lon: locations[0].Lon}
+	queryTraceFrom(ctx).setLocation(location)
+	return location, nil
+}
+
+// geocode looks up the locations matching a city name
+func (d *Datasource) geocode(ctx context.Context, city string, limit int) ([]GeoLocation, error) {
+	var locations []GeoLocation
+	params := url.Values{
+		"q":     {city},
+		"limit": {strconv.Itoa(limit)},
+	}
+	if err := d.fetch(ctx, EndpointGeo, params, &locations); err != nil {
+		return nil, err
+	}
+	return locations, nil
+}
+
+// queryUnits returns the units of a query, falling back to the datasource default
+func (d *Datasource) queryUnits(units string) string {
+	if units == "" {
+		return d.settings.DefaultUnits
+	}
+	return units
+}
+
+// queryLocation fills in the default location of the datasource. The returned notices
+// tell the user when the default was used.
+func (d *Datasource) queryLocation(l locationQuery) (locationQuery, []data.Notice) {
+	if l.City == "" && l.Lat == nil && l.Lon == nil && d.settings.DefaultLocation != "" {
+		l.City = d.settings.DefaultLocation
+		return l, []data.Notice{defaultLocationNotice(l.City)}
+	}
+	return l, nil
+}
@@ -0,0 +1,85 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func queryTypeRequest(queries ...backend.DataQuery) *backend.QueryDataRequest {
+	return &backend.QueryDataRequest{
+		PluginContext: backend.PluginContext{
+			DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{},
+		},
+		Queries: queries,
+	}
+}
+
+func TestQueryTypeDispatch(t *testing.T) {
+	server, _ := newTestServer(t)
+	ds := newTestDatasourceWithServer(server)
+
+	now := time.Unix(1700000000, 0)
+	resp, err := ds.QueryData(context.Background(), queryTypeRequest(
+		backend.DataQuery{RefID: "untyped", JSON: []byte(`{"city": "Marburg", "metric": "main", "format": "temp"}`)},
+		backend.DataQuery{RefID: "forecast", QueryType: QueryTypeForecast, JSON: []byte(`{"city": "Marburg", "metric": "main"}`)},
+		backend.DataQuery{RefID: "current", QueryType: QueryTypeCurrent, JSON: []byte(`{"city": "Marburg"}`)},
+		backend.DataQuery{RefID: "history", QueryType: QueryTypeHistory, JSON: []byte(`{"city": "Marburg", "metric": "main"}`),
+			TimeRange: backend.TimeRange{From: now.Add(-time.Hour), To: now}},
+		backend.DataQuery{RefID: "air", QueryType: QueryTypeAirQuality, JSON: []byte(`{"lat": 50.81, "lon": 8.77}`)},
+		backend.DataQuery{RefID: "geo", QueryType: QueryTypeGeocoding, JSON: []byte(`{"city": "Marburg"}`)},
+		backend.DataQuery{RefID: "alerts", QueryType: QueryTypeAlerts, JSON: []byte(`{"city": "Marburg"}`)},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for refID, res := range resp.Responses {
+		if res.Error != nil {
+			t.Errorf("%s: unexpected error %v", refID, res.Error)
+			continue
+		}
+		if len(res.Frames) != 1 || res.Frames[0].Rows() == 0 {
+			t.Errorf("%s: expected a frame with data", refID)
+		}
+	}
+
+	if aqi, _ := resp.Responses["air"].Frames[0].FieldByName("aqi"); aqi == nil || aqi.At(0).(int64) != 2 {
+		t.Error("air quality frame has no aqi field")
+	}
+}
+
+func TestQueryTypeUnknown(t *testing.T) {
+	ds := newTestDatasource()
+
+	resp, err := ds.QueryData(context.Background(), queryTypeRequest(
+		backend.DataQuery{RefID: "A", QueryType: "tides", JSON: []byte(`{}`)},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := resp.Responses["A"]; res.Status != backend.StatusBadRequest {
+		t.Errorf("expected StatusBadRequest for an unknown query type, got %v", res.Status)
+	}
+}
+
+func TestQueryTypeValidation(t *testing.T) {
+	ds := newTestDatasource()
+
+	resp, err := ds.QueryData(context.Background(), queryTypeRequest(
+		backend.DataQuery{RefID: "lat", QueryType: QueryTypeAirQuality, JSON: []byte(`{"lat": 95, "lon": 8}`)},
+		backend.DataQuery{RefID: "lon", QueryType: QueryTypeCurrent, JSON: []byte(`{"lat": 50}`)},
+		backend.DataQuery{RefID: "limit", QueryType: QueryTypeGeocoding, JSON: []byte(`{"city": "Marburg", "limit": 10}`)},
+		backend.DataQuery{RefID: "range", QueryType: QueryTypeHistory, JSON: []byte(`{"city": "Marburg", "metric": "main"}`)},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for refID, res := range resp.Responses {
+		if res.Status != backend.StatusBadRequest {
+			t.Errorf("%s: expected StatusBadRequest, got %v (%v)", refID, res.Status, res.Error)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/models"
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/plugin/instrumentation"
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/plugin/ratelimit"
+	"github.com/redis/go-redis/v9"
+)
+
+// rateLimitKeyPrefix namespaces the counters in a shared Redis server. Datasources that use
+// the same API key share its counters, the quota belongs to the key.
+const rateLimitKeyPrefix = PluginID + ":ratelimit:"
+
+// sharedLimitBackoff is how long the calls are limited per process after the shared limiter
+// failed, before it is asked again
+const sharedLimitBackoff = 30 * time.Second
+
+// rateLimiter keeps the upstream calls of every API key within the configured limits. With
+// a shared limiter the calls of all Grafana replicas are counted; while it is unreachable
+// the calls are limited per process instead.
+type rateLimiter struct {
+	limits   []ratelimit.Limit
+	shared   ratelimit.Limiter
+	local    ratelimit.Limiter
+	degraded atomic.Bool
+	// retryShared is when the shared limiter is asked again, in Unix nanoseconds
+	retryShared atomic.Int64
+	logger      *instrumentation.Logger
+	metrics     *instrumentation.Metrics
+	now         func() time.Time
+}
+
+// newRateLimiter returns the limiter for the settings. client is only used for a shared
+// rate limit.
+func newRateLimiter(config *models.PluginSettings, client redis.UniversalClient, logger *instrumentation.Logger, metrics *instrumentation.Metrics) *rateLimiter {
+	var limits []ratelimit.Limit
+	if config.RateLimitPerMinute > 0 {
+		limits = append(limits, ratelimit.Limit{Name: "minute", Window: time.Minute, Max: config.RateLimitPerMinute})
+	}
+	if config.RateLimitPerDay > 0 {
+		limits = append(limits, ratelimit.Limit{Name: "day", Window: 24 * time.Hour, Max: config.RateLimitPerDay})
+	}
+
+	l := &rateLimiter{
+		limits:  limits,
+		local:   ratelimit.NewLocal(limits...),
+		logger:  logger,
+		metrics: metrics,
+		now:     time.Now,
+	}
+	if config.SharedRateLimit && client != nil {
+		l.shared = ratelimit.NewRedis(client, rateLimitKeyPrefix, limits...)
+		metrics.SetRateLimiterDegraded(false)
+	}
+	return l
+}
+
+// allow counts a call to endpoint made with k. Calls over a limit fail with ErrRateLimited
+// without reaching OpenWeather, so the key pool can fail over to another key.
+func (l *rateLimiter) allow(ctx context.Context, endpoint Endpoint, k *pooledKey) error {
+	if len(l.limits) == 0 {
+		return nil
+	}
+	res := l.check(ctx, k.id)
+	if res.Allowed {
+		return nil
+	}
+
+	l.metrics.RecordRateLimited(k.id, res.Limit.Name)
+	return &UpstreamError{
+		Kind:     ErrRateLimited,
+		Endpoint: endpoint,
+		Message: fmt.Sprintf("rate limit of %d calls per %s reached for API key %s, retry in %s",
+			res.Limit.Max, res.Limit.Name, k.id, max(res.RetryAfter.Round(time.Second), time.Second)),
+	}
+}
+
+// check asks the shared limiter and falls back to the local one while it fails. After a
+// failure the shared limiter is skipped for sharedLimitBackoff.
+func (l *rateLimiter) check(ctx context.Context, keyID string) ratelimit.Result {
+	if l.shared != nil && l.now().UnixNano() >= l.retryShared.Load() {
+		res, err := l.shared.Allow(ctx, keyID)
+		if err == nil {
+			if l.degraded.CompareAndSwap(true, false) {
+				l.logger.FromContext(ctx).Info("Shared rate limit is reachable again")
+				l.metrics.SetRateLimiterDegraded(false)
+			}
+			return res
+		}
+		if ctx.Err() == nil {
+			l.retryShared.Store(l.now().Add(sharedLimitBackoff).UnixNano())
+			if l.degraded.CompareAndSwap(false, true) {
+				l.logger.FromContext(ctx).Warn("Shared rate limit is unreachable, limiting calls per process", "error", err, "retryIn", sharedLimitBackoff)
+				l.metrics.SetRateLimiterDegraded(true)
+			}
+		}
+	}
+	res, _ := l.local.Allow(ctx, keyID)
+	return res
+}
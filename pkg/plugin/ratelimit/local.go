@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// counter holds the calls of the current and the previous fixed window
+type counter struct {
+	bucket     int64
+	prev, curr int64
+}
+
+// advance moves the counter to bucket
+func (c *counter) advance(bucket int64) {
+	switch {
+	case bucket == c.bucket+1:
+		c.prev, c.curr = c.curr, 0
+	case bucket > c.bucket+1:
+		c.prev, c.curr = 0, 0
+	}
+	c.bucket = bucket
+}
+
+// Local counts the calls of the plugin process
+type Local struct {
+	mu       sync.Mutex
+	limits   []Limit
+	counters map[string][]*counter
+	now      func() time.Time
+}
+
+var _ Limiter = (*Local)(nil)
+
+func NewLocal(limits ...Limit) *Local {
+	return &Local{limits: limits, counters: map[string][]*counter{}, now: time.Now}
+}
+
+func (l *Local) Allow(_ context.Context, key string) (Result, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	counters, ok := l.counters[key]
+	if !ok {
+		counters = make([]*counter, len(l.limits))
+		for i := range counters {
+			counters[i] = &counter{}
+		}
+		l.counters[key] = counters
+	}
+
+	now := l.now()
+	for i, limit := range l.limits {
+		bucket, elapsed := position(limit, now)
+		c := counters[i]
+		c.advance(bucket)
+		if exceeded(limit, c.prev, c.curr, elapsed) {
+			return denied(limit, c.prev, c.curr, elapsed), nil
+		}
+	}
+	for _, c := range counters {
+		c.curr++
+	}
+	return Result{Allowed: true}, nil
+}
@@ -0,0 +1,62 @@
+// Package ratelimit limits the upstream calls made with an API key. Local counts the calls
+// of the plugin process, Redis counts the calls of all Grafana replicas that share a Redis
+// server, so together they stay within the quota of the OpenWeather plan.
+//
+// Both approximate a sliding window with two fixed windows: the calls of the previous
+// window are weighted by how much of it the sliding window still overlaps.
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Limit allows Max calls per Window
+type Limit struct {
+	// Name identifies the limit in counters and metrics, like "minute"
+	Name   string
+	Window time.Duration
+	Max    int
+}
+
+// Result is the outcome of Allow
+type Result struct {
+	Allowed bool
+	// Limit is the limit that was reached if the call is not allowed
+	Limit Limit
+	// RetryAfter estimates when the call would be allowed
+	RetryAfter time.Duration
+}
+
+// Limiter counts calls per key
+type Limiter interface {
+	// Allow counts a call for key if no limit is reached
+	Allow(ctx context.Context, key string) (Result, error)
+}
+
+// position returns the fixed window that now falls into and how far into it now is
+func position(l Limit, now time.Time) (bucket int64, elapsed time.Duration) {
+	ms := now.UnixMilli()
+	window := l.Window.Milliseconds()
+	return ms / window, time.Duration(ms%window) * time.Millisecond
+}
+
+// exceeded reports whether the sliding window holds Max calls. Redis evaluates the same
+// condition in limitScript, integer milliseconds keep both exact.
+func exceeded(l Limit, prev, curr int64, elapsed time.Duration) bool {
+	window, elapsedMs := l.Window.Milliseconds(), elapsed.Milliseconds()
+	return prev*(window-elapsedMs)+curr*window >= int64(l.Max)*window
+}
+
+// denied returns the result for a call that exceeds l
+func denied(l Limit, prev, curr int64, elapsed time.Duration) Result {
+	var wait time.Duration
+	if curr >= int64(l.Max) {
+		// The current window is full: wait for the next one, and then until enough of
+		// the calls of this window have slid out
+		wait = l.Window - elapsed + time.Duration((1-float64(l.Max)/float64(curr))*float64(l.Window))
+	} else if prev > 0 {
+		wait = l.Window - time.Duration(float64(int64(l.Max)-curr)/float64(prev)*float64(l.Window)) - elapsed
+	}
+	return Result{Limit: l, RetryAfter: max(wait, time.Millisecond)}
+}
@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+var testLimits = []Limit{
+	{Name: "minute", Window: time.Minute, Max: 3},
+	{Name: "day", Window: 24 * time.Hour, Max: 5},
+}
+
+// testLimiters returns limiters that run on the clock now
+func testLimiters(t *testing.T, now *time.Time) map[string]func() Limiter {
+	clock := func() time.Time { return *now }
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	return map[string]func() Limiter{
+		"local": func() Limiter {
+			l := NewLocal(testLimits...)
+			l.now = clock
+			return l
+		},
+		"redis": func() Limiter {
+			server.FlushAll()
+			r := NewRedis(client, "test:", testLimits...)
+			r.now = clock
+			return r
+		},
+	}
+}
+
+// allowed counts the calls that Allow lets through out of n
+func allowed(t *testing.T, l Limiter, key string, n int) (int, Result) {
+	t.Helper()
+	var count int
+	var last Result
+	for i := 0; i < n; i++ {
+		res, err := l.Allow(context.Background(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Allowed {
+			count++
+		} else {
+			last = res
+		}
+	}
+	return count, last
+}
+
+func TestLimiters(t *testing.T) {
+	// The start of a day, so the windows line up
+	now := time.Unix(1700006400, 0)
+	for name, newLimiter := range testLimiters(t, &now) {
+		t.Run(name, func(t *testing.T) {
+			start := now
+			defer func() { now = start }()
+			l := newLimiter()
+
+			n, res := allowed(t, l, "a", 5)
+			if n != 3 || res.Limit.Name != "minute" || res.RetryAfter <= 0 || res.RetryAfter > 2*time.Minute {
+				t.Errorf("expected 3 calls within the minute limit, got %d and %+v", n, res)
+			}
+			if n, _ := allowed(t, l, "b", 1); n != 1 {
+				t.Error("expected keys to be limited independently")
+			}
+
+			// Half of the previous minute still counts: 1.5 calls, room for two more
+			now = now.Add(90 * time.Second)
+			if n, _ := allowed(t, l, "a", 3); n != 2 {
+				t.Errorf("expected 2 calls within the sliding window, got %d", n)
+			}
+
+			now = now.Add(10 * time.Minute)
+			n, res = allowed(t, l, "a", 1)
+			if n != 0 || res.Limit.Name != "day" {
+				t.Errorf("expected the day limit to be reached after 5 calls, got %d and %+v", n, res)
+			}
+		})
+	}
+}
+
+func TestRedisIsSharedByReplicas(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	first := NewRedis(client, "test:", testLimits...)
+	second := NewRedis(client, "test:", testLimits...)
+	if n, _ := allowed(t, first, "a", 2); n != 2 {
+		t.Fatalf("expected 2 calls, got %d", n)
+	}
+	if n, _ := allowed(t, second, "a", 2); n != 1 {
+		t.Errorf("expected the second replica to see the calls of the first, got %d calls", n)
+	}
+}
+
+func TestRedisUnreachable(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { _ = client.Close() })
+	server.Close()
+
+	if _, err := NewRedis(client, "test:", testLimits...).Allow(context.Background(), "a"); err == nil {
+		t.Error("expected an error while Redis is unreachable")
+	}
+}
@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// limitScript checks and counts a call atomically. KEYS holds the counters of the current
+// and the previous window of every limit, ARGV the elapsed time, window length and maximum
+// of every limit. It returns {0} if the call was counted, else the 1-based index of the
+// exceeded limit and its counters.
+var limitScript = redis.NewScript(`
+local n = #KEYS / 2
+for i = 1, n do
+  local curr = tonumber(redis.call('GET', KEYS[2 * i - 1]) or '0')
+  local prev = tonumber(redis.call('GET', KEYS[2 * i]) or '0')
+  local elapsed = tonumber(ARGV[3 * i - 2])
+  local window = tonumber(ARGV[3 * i - 1])
+  local max = tonumber(ARGV[3 * i])
+  if prev * (window - elapsed) + curr * window >= max * window then
+    return {i, prev, curr}
+  end
+end
+for i = 1, n do
+  redis.call('INCR', KEYS[2 * i - 1])
+  redis.call('PEXPIRE', KEYS[2 * i - 1], 2 * tonumber(ARGV[3 * i - 1]))
+end
+return {0}
+`)
+
+// Redis counts the calls of all plugin processes that share a Redis server. The clocks of
+// the processes pick the window, they should be synchronized.
+type Redis struct {
+	client redis.UniversalClient
+	prefix string
+	limits []Limit
+	now    func() time.Time
+}
+
+var _ Limiter = (*Redis)(nil)
+
+// NewRedis returns a limiter that stores its counters below prefix. The client is owned
+// by the caller.
+func NewRedis(client redis.UniversalClient, prefix string, limits ...Limit) *Redis {
+	return &Redis{client: client, prefix: prefix, limits: limits, now: time.Now}
+}
+
+func (r *Redis) Allow(ctx context.Context, key string) (Result, error) {
+	now := r.now()
+	keys := make([]string, 0, 2*len(r.limits))
+	args := make([]interface{}, 0, 3*len(r.limits))
+	elapsed := make([]time.Duration, len(r.limits))
+	for i, limit := range r.limits {
+		var bucket int64
+		bucket, elapsed[i] = position(limit, now)
+		// The hash tag keeps the counters of a key in one slot of a Redis cluster
+		counter := r.prefix + "{" + key + "}:" + limit.Name + ":"
+		keys = append(keys, counter+strconv.FormatInt(bucket, 10), counter+strconv.FormatInt(bucket-1, 10))
+		args = append(args, elapsed[i].Milliseconds(), limit.Window.Milliseconds(), limit.Max)
+	}
+
+	reply, err := limitScript.Run(ctx, r.client, keys, args...).Int64Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("could not check the shared rate limit: %w", err)
+	}
+	if reply[0] == 0 {
+		return Result{Allowed: true}, nil
+	}
+	if len(reply) != 3 || reply[0] > int64(len(r.limits)) {
+		return Result{}, fmt.Errorf("unexpected reply of the shared rate limit script: %v", reply)
+	}
+	i := reply[0] - 1
+	return denied(r.limits[i], reply[1], reply[2], elapsed[i]), nil
+}
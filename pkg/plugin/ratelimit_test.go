@@ -0,0 +1,123 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/1DeliDolu/grafana-openweather-datasource/pkg/models"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// queryCity queries the current weather of a city
+func queryCity(t *testing.T, ds *Datasource, city string) backend.DataResponse {
+	t.Helper()
+	resp, err := ds.QueryData(context.Background(), queryTypeRequest(
+		backend.DataQuery{RefID: "A", QueryType: QueryTypeCurrent, JSON: []byte(fmt.Sprintf(`{"city": %q}`, city))},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Responses["A"]
+}
+
+func TestRateLimitPerKey(t *testing.T) {
+	server, requests := newTestServer(t)
+	ds := newTestDatasourceWithServer(server, func(s *models.PluginSettings) {
+		s.Secrets.ApiKeys = []string{"first-key", "second-key"}
+		s.RateLimitPerMinute = 1
+	})
+	before := testMetric(t, "rate_limited_requests_total", map[string]string{"key_id": keyID("first-key"), "limit": "minute"}).GetCounter().GetValue()
+
+	// Every key allows one call, the second query fails over to the second key
+	for _, city := range []string{"Marburg", "Gießen"} {
+		if res := queryCity(t, ds, city); res.Error != nil {
+			t.Fatal(res.Error)
+		}
+	}
+	res := queryCity(t, ds, "Kassel")
+	if res.Error == nil || res.Status != backend.StatusTooManyRequests {
+		t.Errorf("expected the third query to be rate limited, got %v %v", res.Status, res.Error)
+	}
+
+	if want, got := []string{"first-key", "second-key"}, requests.keys(); !slices.Equal(got, want) {
+		t.Errorf("expected OpenWeather to see keys %v, got %v", want, got)
+	}
+	after := testMetric(t, "rate_limited_requests_total", map[string]string{"key_id": keyID("first-key"), "limit": "minute"}).GetCounter().GetValue()
+	if after-before != 1 {
+		t.Errorf("expected 1 rate limited call of the first key, got %v", after-before)
+	}
+}
+
+func TestSharedRateLimitAcrossReplicas(t *testing.T) {
+	redisServer := miniredis.RunT(t)
+	server, requests := newTestServer(t)
+
+	// Two datasource instances stand in for two Grafana replicas
+	replica := func() *Datasource {
+		ds := newTestDatasourceWithServer(server, func(s *models.PluginSettings) {
+			s.Secrets.ApiKey = "shared-key"
+			s.RateLimitPerMinute = 1
+			s.SharedRateLimit = true
+			s.RedisAddress = redisServer.Addr()
+		})
+		t.Cleanup(ds.Dispose)
+		return ds
+	}
+	first, second := replica(), replica()
+
+	if res := queryCity(t, first, "Marburg"); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if res := queryCity(t, second, "Gießen"); res.Status != backend.StatusTooManyRequests {
+		t.Errorf("expected the second replica to be rate limited, got %v %v", res.Status, res.Error)
+	}
+	if n := len(requests.keys()); n != 1 {
+		t.Errorf("expected 1 upstream call, got %d", n)
+	}
+}
+
+func TestSharedRateLimitFallsBackToLocal(t *testing.T) {
+	redisAddr, conns := newUnresponsiveServer(t)
+	server, _ := newTestServer(t)
+	ds := newTestDatasourceWithServer(server, func(s *models.PluginSettings) {
+		s.Secrets.ApiKey = "shared-key"
+		s.RateLimitPerMinute = 1
+		s.SharedRateLimit = true
+		s.RedisAddress = redisAddr
+	})
+	t.Cleanup(ds.Dispose)
+	now := time.Now()
+	ds.limiter.now = func() time.Time { return now }
+
+	start := time.Now()
+	if res := queryCity(t, ds, "Marburg"); res.Error != nil {
+		t.Fatalf("expected the query to be limited locally, got %v", res.Error)
+	}
+	if elapsed := time.Since(start); elapsed > 4*redisTimeout {
+		t.Errorf("expected the fallback after %s, the query took %s", redisTimeout, elapsed)
+	}
+
+	// While degraded the shared limiter is skipped
+	before := conns()
+	start = time.Now()
+	if res := queryCity(t, ds, "Gießen"); res.Status != backend.StatusTooManyRequests {
+		t.Errorf("expected the local limit to apply, got %v %v", res.Status, res.Error)
+	}
+	if elapsed := time.Since(start); elapsed > redisTimeout || conns() != before {
+		t.Errorf("expected the shared limiter to be skipped, the query took %s", elapsed)
+	}
+	if degraded := testMetric(t, "rate_limiter_degraded", nil).GetGauge().GetValue(); degraded != 1 {
+		t.Errorf("expected the rate limiter to report it is degraded, got %v", degraded)
+	}
+
+	// Once the backoff passed the shared limiter is asked again
+	now = now.Add(sharedLimitBackoff)
+	queryCity(t, ds, "Kassel")
+	if conns() == before {
+		t.Error("expected the shared limiter to be asked again after the backoff")
+	}
+}
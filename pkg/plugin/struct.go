@@ -2,10 +2,35 @@ package plugin
 
 // Define the query model to parse the query JSON
 type queryModel struct {
+	// SchemaVersion is the version of the query schema, see query.go
+	SchemaVersion int `json:"schemaVersion"`
+
 	City   string `json:"city"`
 	Format string `json:"format"`
 	Metric string `json:"metric"`
 	Units  string `json:"units"`
+
+	// MainParameter, SubParameter and QueryText are stored by the frontend, they are
+	// migrated into Metric, Format and City
+	MainParameter string `json:"mainParameter,omitempty"`
+	SubParameter  string `json:"subParameter,omitempty"`
+	QueryText     string `json:"queryText,omitempty"`
+
+	// Conditions adds the weather condition code, group, icon and precipitation flags
+	Conditions bool `json:"conditions"`
+
+	// LocalTime adds the local wall-clock time, UTC offset and day/night fields
+	LocalTime bool `json:"localTime"`
+
+	// LocalDays shifts the time field by the city offset so daily boundaries follow local time
+	LocalDays bool `json:"localDays"`
+}
+
+// queryOptions are the options that every query type shares
+type queryOptions struct {
+	// FetchedAtField adds the time the data was fetched from OpenWeather as a field to every
+	// frame. The time is always kept in the frame metadata.
+	FetchedAtField bool `json:"fetchedAtField"`
 }
 
 // Weather API response structures
@@ -87,3 +112,63 @@ type Coord struct {
 	Lat float64 `json:"lat"`
 	Lon float64 `json:"lon"`
 }
+
+// Current weather API response structure
+type CurrentWeatherResponse struct {
+	Dt         int64       `json:"dt"`
+	Name       string      `json:"name"`
+	Coord      Coord       `json:"coord"`
+	Main       MainWeather `json:"main"`
+	Weather    []Weather   `json:"weather"`
+	Clouds     Clouds      `json:"clouds"`
+	Wind       Wind        `json:"wind"`
+	Visibility int         `json:"visibility"`
+	Timezone   int         `json:"timezone"`
+}
+
+// History API response structure
+type HistoryResponse struct {
+	Cod  string         `json:"cod"`
+	Cnt  int            `json:"cnt"`
+	List []ForecastItem `json:"list"`
+}
+
+// Geocoding API response structure
+type GeoLocation struct {
+	Name    string  `json:"name"`
+	Lat     float64 `json:"lat"`
+	Lon     float64 `json:"lon"`
+	Country string  `json:"country"`
+	State   string  `json:"state"`
+}
+
+// Air pollution API response structures
+type AirPollutionResponse struct {
+	Coord Coord              `json:"coord"`
+	List  []AirPollutionItem `json:"list"`
+}
+
+type AirPollutionItem struct {
+	Dt   int64 `json:"dt"`
+	Main struct {
+		AQI int `json:"aqi"`
+	} `json:"main"`
+	Components map[string]float64 `json:"components"`
+}
+
+// One Call API response structures, only the weather alerts are used
+type OneCallResponse struct {
+	Lat      float64        `json:"lat"`
+	Lon      float64        `json:"lon"`
+	Timezone string         `json:"timezone"`
+	Alerts   []WeatherAlert `json:"alerts"`
+}
+
+type WeatherAlert struct {
+	SenderName  string   `json:"sender_name"`
+	Event       string   `json:"event"`
+	Start       int64    `json:"start"`
+	End         int64    `json:"end"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+}
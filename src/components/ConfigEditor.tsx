@@ -1,8 +1,16 @@
 import React, { ChangeEvent } from 'react';
-import { InlineField, SecretInput , Input} from '@grafana/ui';
+import { InlineField, InlineSwitch, SecretInput , Input, RadioButtonGroup } from '@grafana/ui';
 import { DataSourcePluginOptionsEditorProps } from '@grafana/data';
 import { MyDataSourceOptions, MySecureJsonData } from '../types';
 
+type CacheBackend = NonNullable<MyDataSourceOptions['cacheBackend']>;
+
+const cacheBackendOptions: Array<{ label: string; value: CacheBackend; description: string }> = [
+  { label: 'Memory', value: 'memory', description: 'Kept in the plugin process' },
+  { label: 'Disk', value: 'disk', description: 'Survives plugin restarts and Grafana upgrades' },
+  { label: 'Redis', value: 'redis', description: 'Shared by all Grafana replicas' },
+];
+
 interface Props extends DataSourcePluginOptionsEditorProps<MyDataSourceOptions, MySecureJsonData> {}
 
 export function ConfigEditor(props: Props) {
@@ -10,66 +18,228 @@ export function ConfigEditor(props: Props) {
   const { secureJsonFields, secureJsonData, jsonData } = options;
 
 
-  // Secure field (only sent to the backend)
-  const onAPIKeyChange = (event: ChangeEvent<HTMLInputElement>) => {
+  // Secure fields (only sent to the backend)
+  const onSecretChange = (key: keyof MySecureJsonData) => (event: ChangeEvent<HTMLInputElement>) => {
     onOptionsChange({
       ...options,
       secureJsonData: {
-        apiKey: event.target.value,
+        ...options.secureJsonData,
+        [key]: event.target.value,
       },
     });
   };
 
-  const onResetAPIKey = () => {
+  const onResetSecret = (key: keyof MySecureJsonData) => () => {
     onOptionsChange({
       ...options,
       secureJsonFields: {
         ...options.secureJsonFields,
-        apiKey: false,
+        [key]: false,
       },
       secureJsonData: {
         ...options.secureJsonData,
-        apiKey: '',
+        [key]: '',
+      },
+    });
+  };
+
+  // Regular field (sent to the frontend) for the API root
+  const onAPIRootChange = (event: ChangeEvent<HTMLInputElement>) => {
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...jsonData,
+        apiRoot: event.target.value,
+      },
+    });
+  };
+
+  // Plain text settings resolved by the backend
+  const onJSONDataChange = (key: 'apiKeyEnv' | 'apiKeyFile' | 'healthCheckLocation' | 'cacheDir' | 'redisAddress' | 'historyApiRoot') => (event: ChangeEvent<HTMLInputElement>) => {
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...jsonData,
+        [key]: event.target.value,
+      },
+    });
+  };
+
+  const onCacheBackendChange = (cacheBackend: CacheBackend) => {
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...jsonData,
+        cacheBackend,
+      },
+    });
+  };
+
+  const onRedisDBChange = (event: ChangeEvent<HTMLInputElement>) => {
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...jsonData,
+        redisDB: event.target.value === '' ? undefined : Number(event.target.value),
       },
     });
   };
 
-  // Regular field (sent to the frontend) für url
-  const onURLChange = (event: ChangeEvent<HTMLInputElement>) => {
+  const onSharedRateLimitChange = (event: React.FormEvent<HTMLInputElement>) => {
     onOptionsChange({
       ...options,
       jsonData: {
         ...jsonData,
-        url: event.target.value,
+        sharedRateLimit: event.currentTarget.checked,
       },
     });
   };
 
+  const cacheBackend = jsonData.cacheBackend || 'memory';
+  const usesRedis = cacheBackend === 'redis' || Boolean(jsonData.sharedRateLimit);
+
   return (
     <>
     
       <InlineField label="API Key" labelWidth={14} interactive tooltip={'Secure json field (backend only)'}>
         <SecretInput
-          required
           id="config-editor-api-key"
           isConfigured={secureJsonFields.apiKey}
           value={secureJsonData?.apiKey}
           placeholder="Enter your API key"
           width={40}
-          onReset={onResetAPIKey}
-          onChange={onAPIKeyChange}
+          onReset={onResetSecret('apiKey')}
+          onChange={onSecretChange('apiKey')}
+        />
+      </InlineField>
+      <InlineField
+        label="More API Keys"
+        labelWidth={14}
+        interactive
+        tooltip={'Additional API keys separated by commas. Requests are spread across all keys, a rejected or rate limited key is skipped for a while.'}
+      >
+        <SecretInput
+          id="config-editor-api-keys"
+          isConfigured={secureJsonFields.apiKeys}
+          value={secureJsonData?.apiKeys}
+          placeholder="key-2, key-3"
+          width={40}
+          onReset={onResetSecret('apiKeys')}
+          onChange={onSecretChange('apiKeys')}
+        />
+      </InlineField>
+      <InlineField
+        label="API Key Env"
+        labelWidth={14}
+        tooltip={'Environment variable that holds the API keys, separated by commas. Used when no API key is entered above.'}
+      >
+        <Input
+          id="config-editor-api-key-env"
+          value={jsonData.apiKeyEnv || ''}
+          placeholder="OPENWEATHER_API_KEY"
+          width={40}
+          onChange={onJSONDataChange('apiKeyEnv')}
         />
       </InlineField>
-      <InlineField label="URL" labelWidth={14} tooltip={'URL to the weather API'}>
+      <InlineField
+        label="API Key File"
+        labelWidth={14}
+        tooltip={'File that holds one API key per line, e.g. a mounted secret. Used when neither an API key nor a set environment variable is available.'}
+      >
         <Input
-          required
-          id="config-editor-url"
-          value={jsonData.url || ''}
-          placeholder="Enter the URL"
+          id="config-editor-api-key-file"
+          value={jsonData.apiKeyFile || ''}
+          placeholder="/etc/secrets/openweather/apikey"
           width={40}
-          onChange={onURLChange}
+          onChange={onJSONDataChange('apiKeyFile')}
         />
       </InlineField>
+      <InlineField label="API Root" labelWidth={14} tooltip={'Root URL of the OpenWeather API, e.g. https://api.openweathermap.org'}>
+        <Input
+          id="config-editor-api-root"
+          value={jsonData.apiRoot || jsonData.url || jsonData.path || ''}
+          placeholder="https://api.openweathermap.org"
+          width={40}
+          onChange={onAPIRootChange}
+        />
+      </InlineField>
+      <InlineField
+        label="History Root"
+        labelWidth={14}
+        tooltip={'Root URL of the OpenWeather history API. Defaults to https://history.openweathermap.org, or the API root if it is customized.'}
+      >
+        <Input
+          id="config-editor-history-api-root"
+          value={jsonData.historyApiRoot || ''}
+          placeholder="https://history.openweathermap.org"
+          width={40}
+          onChange={onJSONDataChange('historyApiRoot')}
+        />
+      </InlineField>
+      <InlineField
+        label="Test Location"
+        labelWidth={14}
+        tooltip={'City or "lat,lon" queried by Save & test. Defaults to the default location, or London.'}
+      >
+        <Input
+          id="config-editor-health-check-location"
+          value={jsonData.healthCheckLocation || ''}
+          placeholder={jsonData.defaultLocation || 'London'}
+          width={40}
+          onChange={onJSONDataChange('healthCheckLocation')}
+        />
+      </InlineField>
+      <InlineField label="Cache" labelWidth={14} tooltip={'Where upstream responses are cached'}>
+        <RadioButtonGroup options={cacheBackendOptions} value={cacheBackend} onChange={onCacheBackendChange} />
+      </InlineField>
+      {cacheBackend === 'disk' && (
+        <InlineField
+          label="Cache Directory"
+          labelWidth={14}
+          tooltip={'Absolute path of the cache directory. Defaults to a directory below the Grafana data directory.'}
+        >
+          <Input
+            id="config-editor-cache-dir"
+            value={jsonData.cacheDir || ''}
+            placeholder="/var/lib/grafana/plugins-cache/grafana-openweather-datasource"
+            width={40}
+            onChange={onJSONDataChange('cacheDir')}
+          />
+        </InlineField>
+      )}
+      <InlineField
+        label="Shared Limit"
+        labelWidth={14}
+        tooltip={'Count the calls of every API key in Redis, so the rate limits hold across all Grafana replicas. While Redis is unreachable each replica limits its own calls.'}
+      >
+        <InlineSwitch id="config-editor-shared-rate-limit" value={jsonData.sharedRateLimit || false} onChange={onSharedRateLimitChange} />
+      </InlineField>
+      {usesRedis && (
+        <>
+          <InlineField label="Redis Address" labelWidth={14} tooltip={'host:port of the Redis server, comma separated for a cluster'} required>
+            <Input
+              id="config-editor-redis-address"
+              value={jsonData.redisAddress || ''}
+              placeholder="redis:6379"
+              width={40}
+              onChange={onJSONDataChange('redisAddress')}
+            />
+          </InlineField>
+          <InlineField label="Redis DB" labelWidth={14}>
+            <Input id="config-editor-redis-db" type="number" min={0} value={jsonData.redisDB ?? ''} placeholder="0" width={40} onChange={onRedisDBChange} />
+          </InlineField>
+          <InlineField label="Redis Password" labelWidth={14}>
+            <SecretInput
+              id="config-editor-redis-password"
+              isConfigured={secureJsonFields.redisPassword}
+              value={secureJsonData?.redisPassword}
+              width={40}
+              onReset={onResetSecret('redisPassword')}
+              onChange={onSecretChange('redisPassword')}
+            />
+          </InlineField>
+        </>
+      )}
     </>
   );
 }
@@ -1,5 +1,5 @@
 import React from 'react';
-import { InlineField, Stack, Select } from '@grafana/ui';
+import { InlineField, InlineSwitch, Stack, Select } from '@grafana/ui';
 import { QueryEditorProps, SelectableValue } from '@grafana/data';
 import { DataSource } from '../datasource';
 import { MyDataSourceOptions, MyQuery } from '../types';
@@ -87,6 +87,38 @@ export function QueryEditor({ query, onChange, onRunQuery }: Props) {
     onRunQuery();
   };
 
+  const onConditionsChange = (e: React.FormEvent<HTMLInputElement>) => {
+    onChange({
+      ...query,
+      conditions: e.currentTarget.checked,
+    });
+    onRunQuery();
+  };
+
+  const onLocalTimeChange = (e: React.FormEvent<HTMLInputElement>) => {
+    onChange({
+      ...query,
+      localTime: e.currentTarget.checked,
+    });
+    onRunQuery();
+  };
+
+  const onLocalDaysChange = (e: React.FormEvent<HTMLInputElement>) => {
+    onChange({
+      ...query,
+      localDays: e.currentTarget.checked,
+    });
+    onRunQuery();
+  };
+
+  const onFetchedAtFieldChange = (e: React.FormEvent<HTMLInputElement>) => {
+    onChange({
+      ...query,
+      fetchedAtField: e.currentTarget.checked,
+    });
+    onRunQuery();
+  };
+
   // Make sure we have default values
   const mainParameter = query.mainParameter || 'main';
   const subParameter = query.subParameter || 
@@ -147,6 +179,30 @@ export function QueryEditor({ query, onChange, onRunQuery }: Props) {
           />
         </InlineField>
       </div>
+
+      <div>
+        <InlineField label="Conditions" labelWidth={20} tooltip="Add condition code, group, icon and precipitation fields">
+          <InlineSwitch value={query.conditions || false} onChange={onConditionsChange} />
+        </InlineField>
+      </div>
+
+      <div>
+        <InlineField label="Local Time" labelWidth={20} tooltip="Add local time, UTC offset and day/night fields">
+          <InlineSwitch value={query.localTime || false} onChange={onLocalTimeChange} />
+        </InlineField>
+      </div>
+
+      <div>
+        <InlineField label="Local Days" labelWidth={20} tooltip="Shift daily boundaries into the location's local time">
+          <InlineSwitch value={query.localDays || false} onChange={onLocalDaysChange} />
+        </InlineField>
+      </div>
+
+      <div>
+        <InlineField label="Fetched At" labelWidth={20} tooltip="Add the time the data was fetched from OpenWeather, to show the age of cached data">
+          <InlineSwitch value={query.fetchedAtField || false} onChange={onFetchedAtFieldChange} />
+        </InlineField>
+      </div>
     </Stack>
   );
 }
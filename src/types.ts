@@ -7,6 +7,10 @@ export interface MyQuery extends DataQuery {
   subParameter: string;  // Keep as single string since backend expects one value
   units: 'standard' | 'metric' | 'imperial';
   queryText?: string;  // for template variables
+  conditions?: boolean;  // add condition code, group, icon and precipitation fields
+  localTime?: boolean;  // add local time, UTC offset and day/night fields
+  localDays?: boolean;  // shift daily boundaries into the location's local time
+  fetchedAtField?: boolean;  // add the time the data was fetched from OpenWeather as a field
 }
 
 export const DEFAULT_QUERY: Partial<MyQuery> = {
@@ -59,7 +63,41 @@ export interface WeatherData {
  * These are options configured for each DataSource instance
  */
 export interface MyDataSourceOptions extends DataSourceJsonData {
+  schemaVersion?: number;
+  apiRoot?: string;
+  /** Root of the history API, defaults to https://history.openweathermap.org, or apiRoot if that is customized */
+  historyApiRoot?: string;
+  /** Environment variable that holds the API keys separated by commas, used when no key is stored */
+  apiKeyEnv?: string;
+  /** File that holds one API key per line, used when no key is stored and apiKeyEnv is unset */
+  apiKeyFile?: string;
+  defaultUnits?: 'standard' | 'metric' | 'imperial';
+  defaultLanguage?: string;
+  defaultLocation?: string;
+  /** City or "lat,lon" queried by the health check, defaults to defaultLocation or London */
+  healthCheckLocation?: string;
+  timeoutSeconds?: number;
+  cacheTTLSeconds?: number;
+  /** How long stale responses are served after cacheTTLSeconds, while refreshing or during outages */
+  cacheStaleSeconds?: number;
+  /** Where cached responses are kept: in the plugin process, on disk or in Redis shared by all replicas */
+  cacheBackend?: 'memory' | 'disk' | 'redis';
+  /** Directory of the disk cache, defaults to a directory below the Grafana data directory */
+  cacheDir?: string;
+  /** Address of the Redis server used by the redis cache and the shared rate limit, or comma separated cluster addresses */
+  redisAddress?: string;
+  redisDB?: number;
+  maxConcurrency?: number;
+  /** How often a call is sent again when OpenWeather is unavailable, timeouts are not retried */
+  maxRetries?: number;
+  rateLimitPerMinute?: number;
+  rateLimitPerDay?: number;
+  /** Count the calls of every API key in Redis, so the rate limits hold across all Grafana replicas */
+  sharedRateLimit?: boolean;
+  /** @deprecated replaced by apiRoot, still read by the backend */
   url?: string;
+  /** @deprecated replaced by apiRoot, still read by the backend */
+  path?: string;
 }
 
 /**
@@ -67,4 +105,7 @@ export interface MyDataSourceOptions extends DataSourceJsonData {
  */
 export interface MySecureJsonData {
   apiKey?: string;
+  /** Additional API keys separated by commas or newlines, calls are spread across all keys */
+  apiKeys?: string;
+  redisPassword?: string;
 }
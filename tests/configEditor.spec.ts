@@ -4,7 +4,7 @@ import { MyDataSourceOptions, MySecureJsonData } from '../src/types';
 test('smoke: should render config editor', async ({ createDataSourceConfigPage, readProvisionedDataSource, page }) => {
   const ds = await readProvisionedDataSource({ fileName: 'datasources.yml' });
   await createDataSourceConfigPage({ type: ds.type });
-  await expect(page.getByLabel('Path')).toBeVisible();
+  await expect(page.getByLabel('API Root')).toBeVisible();
 });
 test('"Save & test" should be successful when configuration is valid', async ({
   createDataSourceConfigPage,
@@ -13,7 +13,7 @@ test('"Save & test" should be successful when configuration is valid', async ({
 }) => {
   const ds = await readProvisionedDataSource<MyDataSourceOptions, MySecureJsonData>({ fileName: 'datasources.yml' });
   const configPage = await createDataSourceConfigPage({ type: ds.type });
-  await page.getByRole('textbox', { name: 'Path' }).fill(ds.jsonData.path ?? '');
+  await page.getByRole('textbox', { name: 'API Root' }).fill(ds.jsonData.apiRoot ?? '');
   await page.getByRole('textbox', { name: 'API Key' }).fill(ds.secureJsonData?.apiKey ?? '');
   await expect(configPage.saveAndTest()).toBeOK();
 });
@@ -25,7 +25,7 @@ test('"Save & test" should fail when configuration is invalid', async ({
 }) => {
   const ds = await readProvisionedDataSource<MyDataSourceOptions, MySecureJsonData>({ fileName: 'datasources.yml' });
   const configPage = await createDataSourceConfigPage({ type: ds.type });
-  await page.getByRole('textbox', { name: 'Path' }).fill(ds.jsonData.path ?? '');
+  await page.getByRole('textbox', { name: 'API Root' }).fill(ds.jsonData.apiRoot ?? '');
   await expect(configPage.saveAndTest()).not.toBeOK();
   await expect(configPage).toHaveAlert('error', { hasText: 'API key is missing' });
 });